@@ -8,26 +8,26 @@ import (
 // rdcssDescriptor is an intermediate struct which communicates the intent to
 // replace the value in an I-node and check that the root's generation has not
 // changed before committing to the new value.
-type rdcssDescriptor struct {
-	old       *iNode
-	expected  *node
-	nv        *iNode
+type rdcssDescriptor[K, V any] struct {
+	old       *iNode[K, V]
+	expected  *node[K, V]
+	nv        *iNode[K, V]
 	committed int32
 }
 
-func newRdcssDescriptor(o *iNode, m *node, n *iNode) *rdcssDescriptor {
-	return &rdcssDescriptor{
+func newRdcssDescriptor[K, V any](o *iNode[K, V], m *node[K, V], n *iNode[K, V]) *rdcssDescriptor[K, V] {
+	return &rdcssDescriptor[K, V]{
 		old:      o,
 		expected: m,
 		nv:       n,
 	}
 }
 
-func (d *rdcssDescriptor) Load(i int32) bool {
+func (d *rdcssDescriptor[K, V]) Load(i int32) bool {
 	return atomic.LoadInt32(&d.committed) == i
 }
 
-func (d *rdcssDescriptor) Set(i int32) {
+func (d *rdcssDescriptor[K, V]) Set(i int32) {
 	atomic.StoreInt32(&d.committed, i)
 }
 
@@ -37,15 +37,15 @@ func (d *rdcssDescriptor) Set(i int32) {
 // }
 
 // func casRoot(r unsafe.Pointer, ov, nv *iNode) bool {
-func casRoot(r **iNode, ov, nv *iNode) bool {
+func casRoot[K, V any](r **iNode[K, V], ov, nv *iNode[K, V]) bool {
 	return atomic.CompareAndSwapPointer(upp(unsafe.Pointer(r)), unsafe.Pointer(ov), unsafe.Pointer(nv))
 }
 
-func casINode(r **iNode, ov, nv *iNode) bool {
+func casINode[K, V any](r **iNode[K, V], ov, nv *iNode[K, V]) bool {
 	return atomic.CompareAndSwapPointer(upp(unsafe.Pointer(r)), unsafe.Pointer(ov), unsafe.Pointer(nv))
 }
 
-func casMainNode(r **node, ov, nv *node) bool {
+func casMainNode[K, V any](r **node[K, V], ov, nv *node[K, V]) bool {
 	return atomic.CompareAndSwapPointer(upp(unsafe.Pointer(r)), unsafe.Pointer(ov), unsafe.Pointer(nv))
 }
 
@@ -54,17 +54,17 @@ func upp(p unsafe.Pointer) *unsafe.Pointer {
 }
 
 // atomic local inode
-func alin(root **iNode) *iNode {
-	return (*iNode)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(root))))
+func alin[K, V any](root **iNode[K, V]) *iNode[K, V] {
+	return (*iNode[K, V])(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(root))))
 }
 
 // atomic load node
-func aln(main **node) *node {
-	return (*node)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(main))))
+func aln[K, V any](main **node[K, V]) *node[K, V] {
+	return (*node[K, V])(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(main))))
 }
 
 // gcasRead performs a GCAS-linearizable read of the I-node's main node.
-func gcasRead(in *iNode, c *ctrie) *node {
+func gcasRead[K, V any](in *iNode[K, V], c *ctrie[K, V]) *node[K, V] {
 	// m := (*node)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&in.main))))
 	// prev := (*node)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.prev))))
 	m := aln(&in.main)
@@ -75,7 +75,7 @@ func gcasRead(in *iNode, c *ctrie) *node {
 	return gcasComplete(in, m, c.rdcssCompleteAbort())
 }
 
-func gcasComplete(i *iNode, m *node, root *iNode) *node {
+func gcasComplete[K, V any](i *iNode[K, V], m *node[K, V], root *iNode[K, V]) *node[K, V] {
 	for prev := aln(&m.prev); m != nil && prev != nil; prev = aln(&m.prev) {
 		switch {
 		// Signals GCAS failure. Swap old value back into I-node.
@@ -92,7 +92,7 @@ func gcasComplete(i *iNode, m *node, root *iNode) *node {
 		default:
 			// Generations did not match. Store failed node on prev to signal
 			// I-node's main node must be set back to the previous value.
-			casMainNode(&m.prev, prev, &node{failed: prev})
+			casMainNode(&m.prev, prev, &node[K, V]{failed: prev})
 			m = aln(&i.main)
 		}
 	}
@@ -104,7 +104,7 @@ func gcasComplete(i *iNode, m *node, root *iNode) *node {
 // failures that occur due to the snapshot being taken. This ensures that the
 // write occurs only if the Ctrie root generation has remained the same in
 // addition to the I-node having the expected value.
-func gcas(in *iNode, old, n *node, c *ctrie) bool {
+func gcas[K, V any](in *iNode[K, V], old, n *node[K, V], c *ctrie[K, V]) bool {
 	prevPtr := (*unsafe.Pointer)(unsafe.Pointer(&n.prev))
 	atomic.StorePointer(prevPtr, unsafe.Pointer(old))
 	if atomic.CompareAndSwapPointer(
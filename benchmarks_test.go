@@ -111,3 +111,58 @@ func BenchmarkSledSetGetParallel(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkTypedMapSetGet(b *testing.B) {
+	m := sled.NewMap[int]()
+	for n := 0; n < b.N; n++ {
+		m.Store(strconv.Itoa(n), n)
+	}
+	for n := 0; n < b.N; n++ {
+		v, _ := m.Load(strconv.Itoa(n))
+		if v != n {
+			b.Fail()
+		}
+	}
+}
+
+// assertNoAllocs fails the benchmark if fn allocates.
+func assertNoAllocs(b *testing.B, fn func()) {
+	if allocs := testing.AllocsPerRun(100, fn); allocs != 0 {
+		b.Fatalf("expected 0 allocs/op, got %v", allocs)
+	}
+}
+
+func BenchmarkSledGetExisting(b *testing.B) {
+	sl := sled.New()
+	keys := make([]string, 1000)
+	for n := range keys {
+		keys[n] = strconv.Itoa(n)
+		sl.Set(keys[n], n)
+	}
+	var v int
+	assertNoAllocs(b, func() {
+		_ = sl.Get(keys[500], &v)
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = sl.Get(keys[n%len(keys)], &v)
+	}
+}
+
+func BenchmarkTypedMapLoadExisting(b *testing.B) {
+	m := sled.NewMap[int]()
+	keys := make([]string, 1000)
+	for n := range keys {
+		keys[n] = strconv.Itoa(n)
+		m.Store(keys[n], n)
+	}
+	assertNoAllocs(b, func() {
+		m.Load(keys[500])
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.Load(keys[n%len(keys)])
+	}
+}
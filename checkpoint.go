@@ -0,0 +1,207 @@
+package sled
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A checkpoint holds the state of a durable sled as of a sequence number of
+// its log, so the log segments before it can be dropped:
+//
+//	checkpoint = magic seq:uint64 snapshot
+//
+// The magic is "SLEDCKPT", seq is little endian, and snapshot is a snapshot
+// file as written by WriteTo. A checkpoint is written to a temporary file
+// which is renamed over the previous one, so there is always one whole
+// checkpoint, or none.
+const (
+	checkpointMagic = "SLEDCKPT"
+	checkpointName  = "checkpoint"
+	checkpointTemp  = "checkpoint.tmp"
+)
+
+// ErrNotDurable is returned by Checkpoint on a sled which was not created by
+// Open.
+var ErrNotDurable = errors.New("sled is not durable")
+
+// checkpointer checkpoints a durable sled periodically.
+type checkpointer struct {
+	mu   sync.Mutex // serializes checkpoints
+	seq  uint64     // the sequence number of the last checkpoint
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// err is the error of the last background checkpoint, if it failed.
+	err error
+
+	// hook is called after each step of a checkpoint, with mu held, for
+	// tests to simulate a crash at that step.
+	hook func(step string)
+}
+
+func (c *checkpointer) step(step string) {
+	if c.hook != nil {
+		c.hook(step)
+	}
+}
+
+func (c *checkpointer) start(s *sled, interval time.Duration) {
+	c.done = make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := s.Checkpoint()
+				c.mu.Lock()
+				c.err = err
+				c.mu.Unlock()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the background checkpoints, returning the error of the last
+// one.
+func (c *checkpointer) stop() error {
+	if c.done != nil {
+		close(c.done)
+		c.wg.Wait()
+		c.done = nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Checkpoint writes the state of a durable sled to a checkpoint file in its
+// directory, and drops the log segments the checkpoint replaces, so the
+// next Open loads the checkpoint and replays only the log written since.
+// Writes are paused only while a read-only snapshot is taken; the snapshot
+// is written out while they go on. WithCheckpointInterval checkpoints in the
+// background. A sled not created by Open returns ErrNotDurable.
+func (s *sled) Checkpoint() error {
+	w := s.wal
+	if w == nil {
+		return ErrNotDurable
+	}
+	c := &w.checkpoint
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The snapshot holds the writes logged so far, and no others. A new
+	// segment is started with the next write, so the segments before it
+	// hold only writes in the snapshot.
+	w.mu.Lock()
+	if w.f == nil || w.err != nil {
+		err := w.err
+		w.mu.Unlock()
+		if err == nil {
+			err = os.ErrClosed
+		}
+		return err
+	}
+	seq := w.seq
+	if seq == c.seq {
+		w.mu.Unlock()
+		return nil
+	}
+	snap := s.Snapshot(ReadOnly)
+	if w.start <= seq {
+		w.err = w.rotate()
+	}
+	err := w.err
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.step("snapshot")
+
+	if err := c.write(w.dir, seq, snap); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(w.dir, checkpointTemp), filepath.Join(w.dir, checkpointName)); err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		return err
+	}
+	c.seq = seq
+	c.step("renamed")
+
+	paths, err := segments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if start, err := segmentStart(path); err != nil || start > seq {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		c.step("pruned")
+	}
+	return syncDir(w.dir)
+}
+
+// write writes snap, which holds the writes up to seq, to the temporary
+// checkpoint file in dir, and flushes it to stable storage.
+func (c *checkpointer) write(dir string, seq uint64, snap Sled) error {
+	f, err := os.Create(filepath.Join(dir, checkpointTemp))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := binary.LittleEndian.AppendUint64([]byte(checkpointMagic), seq)
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	c.step("header")
+	if _, err := snap.WriteTo(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	c.step("written")
+	return f.Close()
+}
+
+// readCheckpoint loads the checkpoint in dir into the sled, if there is
+// one, and returns the sequence number it was taken at. A temporary file left
+// by a checkpoint interrupted by a crash is removed.
+func (s *sled) readCheckpoint(dir string, pending *[]deadline) (uint64, error) {
+	if err := os.Remove(filepath.Join(dir, checkpointTemp)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	f, err := os.Open(filepath.Join(dir, checkpointName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, len(checkpointMagic)+8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(checkpointMagic)]) != checkpointMagic {
+		return 0, fmt.Errorf("%s: %w", f.Name(), ErrInvalidSnapshot)
+	}
+	if err := s.readSnapshot(r, pending); err != nil {
+		return 0, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return binary.LittleEndian.Uint64(header[len(checkpointMagic):]), nil
+}
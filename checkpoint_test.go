@@ -0,0 +1,93 @@
+package sled
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// crashWorkload writes to a durable sled in dir, checkpoints it, writes some
+// more and checkpoints it again, exiting the process at step of the second
+// checkpoint.
+func crashWorkload(dir, step string) {
+	s, err := Open(dir, WithSegmentSize(512), WithSyncPolicy(SyncNever))
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 100; i++ {
+		s.Set(strconv.Itoa(i), i)
+	}
+	if err := s.Checkpoint(); err != nil {
+		panic(err)
+	}
+	for i := 50; i < 150; i++ {
+		s.Set(strconv.Itoa(i), -i)
+	}
+	for i := 0; i < 10; i++ {
+		s.Delete(strconv.Itoa(i))
+	}
+	s.(*sled).wal.checkpoint.hook = func(at string) {
+		if at == step {
+			os.Exit(3)
+		}
+	}
+	s.Checkpoint()
+	os.Exit(0)
+}
+
+// checkWorkload checks that the sled in dir holds what crashWorkload wrote.
+func checkWorkload(t *testing.T, dir string) {
+	assert := assert.New(t)
+	s, err := Open(dir)
+	if !assert.NoError(err) {
+		return
+	}
+	defer s.Close()
+	assert.Equal(uint(140), s.Len())
+	for i := 10; i < 150; i++ {
+		want := i
+		if i >= 50 {
+			want = -i
+		}
+		var v int
+		assert.NoError(s.Get(strconv.Itoa(i), &v))
+		assert.Equal(want, v)
+	}
+}
+
+func TestCheckpointCrash(t *testing.T) {
+	if step := os.Getenv("SLED_CRASH_STEP"); step != "" {
+		crashWorkload(os.Getenv("SLED_CRASH_DIR"), step)
+		return
+	}
+	assert := assert.New(t)
+	// The last step is never reached, so the workload runs to the end.
+	for _, step := range []string{"snapshot", "header", "written", "renamed", "pruned", "none"} {
+		dir := t.TempDir()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCheckpointCrash$")
+		cmd.Env = append(os.Environ(), "SLED_CRASH_DIR="+dir, "SLED_CRASH_STEP="+step)
+		err := cmd.Run()
+		var exit *exec.ExitError
+		if step == "none" {
+			assert.NoError(err)
+		} else if assert.True(errors.As(err, &exit), step) {
+			assert.Equal(3, exit.ExitCode(), step)
+		}
+		checkWorkload(t, dir)
+
+		// The recovered sled checkpoints and recovers again.
+		s, err := Open(dir)
+		if assert.NoError(err) {
+			assert.NoError(s.Checkpoint())
+			assert.NoError(s.Close())
+			checkWorkload(t, dir)
+		}
+		_, err = os.Stat(filepath.Join(dir, checkpointTemp))
+		assert.True(os.IsNotExist(err), step)
+	}
+}
@@ -0,0 +1,209 @@
+package sled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Codec encodes values to bytes and back, for the log of a durable sled and
+// for snapshot files. A codec encodes the value alone; the concrete type of
+// the value is recorded beside it by name, from the type registry, so the
+// value is decoded into a new value of the same type. See RegisterType.
+type Codec interface {
+	// Name identifies the codec in the files it encodes.
+	Name() string
+
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value v points to. It must not keep
+	// data, which may be a file mapped into memory by OpenTrie.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// GobCodec encodes values with encoding/gob. It is the default codec.
+type GobCodec struct{}
+
+func (GobCodec) Name() string { return "gob" }
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// JSONCodec encodes values with encoding/json. Only the exported fields of
+// structs are encoded.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// RawCodec stores []byte values as they are, and refuses values of any
+// other type. It suits sleds of values which are already encoded.
+type RawCodec struct{}
+
+func (RawCodec) Name() string { return "raw" }
+
+func (RawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec cannot encode a value of type %T", v)
+	}
+	return b, nil
+}
+
+func (RawCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec cannot decode into a value of type %T", v)
+	}
+	*p = append([]byte(nil), data...)
+	return nil
+}
+
+// codecNamed returns c if it is called name, or else the built-in codec
+// called name.
+func codecNamed(name string, c Codec) (Codec, bool) {
+	if c != nil && c.Name() == name {
+		return c, true
+	}
+	for _, c := range []Codec{GobCodec{}, JSONCodec{}, RawCodec{}} {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// registry maps the names of the types values are decoded into to the types,
+// and back.
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}{
+	types: make(map[string]reflect.Type),
+	names: make(map[reflect.Type]string),
+}
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", []byte(nil),
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+		[]string(nil), []int(nil), []int64(nil), []float64(nil), []interface{}(nil),
+		map[string]string(nil), map[string]int(nil), map[string]interface{}(nil),
+		time.Time{}, time.Duration(0),
+	} {
+		RegisterType(v)
+	}
+}
+
+// RegisterType records the concrete type of value under a name derived from
+// its package path and type name, so values of the type can be encoded by a
+// Codec and decoded back into the same type, for instance after Open or Load.
+// Types must be registered before values of them are written to a log or a
+// snapshot file, and before they are read back. The basic types, slices of
+// some of them, string keyed maps, time.Time and time.Duration are
+// registered already.
+func RegisterType(value interface{}) {
+	RegisterTypeName(typeName(reflect.TypeOf(value)), value)
+}
+
+// RegisterTypeName is RegisterType under a chosen name, which keeps files
+// readable when a type is renamed or moved. It panics if the name or the type
+// is already registered otherwise.
+func RegisterTypeName(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	if t == nil || name == "" {
+		panic("sled: registering a nil type or an empty name")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if prev, ok := registry.types[name]; ok && prev != t {
+		panic(fmt.Sprintf("sled: registering duplicate types for %q: %s != %s", name, prev, t))
+	}
+	if prev, ok := registry.names[t]; ok && prev != name {
+		panic(fmt.Sprintf("sled: registering duplicate names for %s: %q != %q", t, prev, name))
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+}
+
+// typeName names a type by its package path and name, as gob.Register does.
+func typeName(t reflect.Type) string {
+	switch {
+	case t.Name() != "" && t.PkgPath() != "":
+		return t.PkgPath() + "." + t.Name()
+	case t.Kind() == reflect.Ptr:
+		return "*" + typeName(t.Elem())
+	}
+	return t.String()
+}
+
+// EncodeValue appends the encoding of v by c to buf: the registered name of
+// its type, then the value. A nil value has an empty name.
+//
+//	value = namelen:uvarint name data
+func EncodeValue(buf []byte, c Codec, v interface{}) ([]byte, error) {
+	if v == nil {
+		return binary.AppendUvarint(buf, 0), nil
+	}
+	t := reflect.TypeOf(v)
+	registry.RLock()
+	name, ok := registry.names[t]
+	registry.RUnlock()
+	if !ok {
+		return buf, fmt.Errorf("type %s is not registered", t)
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(name)))
+	buf = append(buf, name...)
+	return append(buf, data...), nil
+}
+
+// DecodeValue decodes a value encoded by EncodeValue with c, returning it as
+// the type it was encoded from.
+func DecodeValue(c Codec, data []byte) (interface{}, error) {
+	n, m := binary.Uvarint(data)
+	if m <= 0 || uint64(len(data)-m) < n {
+		return nil, fmt.Errorf("malformed value")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	name := string(data[m : m+int(n)])
+	registry.RLock()
+	t, ok := registry.types[name]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("type %q is not registered", name)
+	}
+	p := reflect.New(t)
+	if err := c.Unmarshal(data[m+int(n):], p.Interface()); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return p.Elem().Interface(), nil
+}
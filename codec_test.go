@@ -0,0 +1,52 @@
+package sled
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecPoint struct {
+	X, Y int
+}
+
+func TestEncodeValue(t *testing.T) {
+	assert := assert.New(t)
+	RegisterType(codecPoint{})
+	RegisterType(&codecPoint{})
+	RegisterType(codecPoint{}) // registering again is harmless
+
+	now := time.Now().UTC().Round(0)
+	values := []interface{}{nil, 42, "text", []byte("raw"), 1.5, []string{"a"}, now, codecPoint{1, 2}, &codecPoint{3, 4}}
+	for _, c := range []Codec{GobCodec{}, JSONCodec{}} {
+		for _, v := range values {
+			buf, err := EncodeValue(nil, c, v)
+			assert.NoError(err, c.Name())
+			got, err := DecodeValue(c, buf)
+			assert.NoError(err, c.Name())
+			assert.Equal(v, got, c.Name())
+		}
+	}
+
+	buf, err := EncodeValue(nil, RawCodec{}, []byte("raw"))
+	assert.NoError(err)
+	got, err := DecodeValue(RawCodec{}, buf)
+	assert.NoError(err)
+	assert.Equal([]byte("raw"), got)
+	_, err = EncodeValue(nil, RawCodec{}, "not bytes")
+	assert.Error(err)
+
+	type unregistered struct{}
+	_, err = EncodeValue(nil, GobCodec{}, unregistered{})
+	assert.Error(err)
+	buf, _ = EncodeValue(nil, GobCodec{}, 1)
+	buf[1] = 'x' // "int" becomes "xnt"
+	_, err = DecodeValue(GobCodec{}, buf)
+	assert.Error(err)
+
+	assert.Panics(func() { RegisterTypeName("int", "") })
+	assert.Panics(func() { RegisterTypeName("another name", codecPoint{}) })
+	assert.Equal("github.com/Avalanche-io/sled.codecPoint", typeName(reflect.TypeOf(codecPoint{})))
+}
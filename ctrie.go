@@ -1,23 +1,19 @@
 package sled
 
-import (
-	"hash"
-	"hash/fnv"
-)
+import "sync/atomic"
 
-// hasher returns a new Hash64 used to hash keys.
-type hasher func() hash.Hash64
+// Ctrie is a concurrent, lock-free hash trie. Keys are hashed and compared
+// by the keyer the Ctrie is created with. The writes of a read-only snapshot
+// (Insert, Swap, InsertIf, Remove, RemoveIf, Update and Clear) panic with
+// ErrReadOnly; callers which must not panic check writable first.
+type ctrie[K, V any] struct {
+	root     *iNode[K, V]
+	readOnly bool
+	keys     keyer[K]
 
-func defaulthasher() hash.Hash64 {
-	return fnv.New64a()
-}
-
-// Ctrie is a concurrent, lock-free hash trie. By default, keys are hashed
-// using FNV-1a unless a hasher is provided to New.
-type ctrie struct {
-	root        *iNode
-	readOnly    bool
-	hashFactory hasher
+	// length counts keys as inserts and removes complete. It is only
+	// approximate while writes are in flight.
+	length int64
 }
 
 // generation demarcates Ctrie snapshots. We use a heap-allocated reference
@@ -30,73 +26,205 @@ type generation struct{ _ int }
 type branch interface{}
 
 // Entry contains a Ctrie key-value pair.
-type entry struct {
-	Key   []byte
-	Value interface{}
+type entry[K, V any] struct {
+	Key   K
+	Value V
 	hash  uint64
 }
 
-// New creates an empty Ctrie which uses the provided hasher for key
-// hashing. If nil is passed in, it will default to FNV-1a hashing.
-func newCtrie(hashFactory hasher) *ctrie {
-	if hashFactory == nil {
-		hashFactory = defaulthasher
+// withValue returns a copy of the entry holding the given value.
+func (e *entry[K, V]) withValue(v V) *entry[K, V] {
+	return &entry[K, V]{Key: e.Key, Value: v, hash: e.hash}
+}
+
+// condition is evaluated against the value currently stored for a key at
+// the linearization point of a conditional write. The write only takes place
+// if the condition holds. A nil condition always holds.
+type condition[V any] func(old V, exists bool) bool
+
+func (cond condition[V]) allows(old V, exists bool) bool {
+	return cond == nil || cond(old, exists)
+}
+
+// recorded returns a condition which stores in allowed what cond decided
+// each time it is evaluated, so the caller learns the decision of the
+// attempt which took place without evaluating cond again.
+func (cond condition[V]) recorded(allowed *bool) condition[V] {
+	*allowed = true
+	if cond == nil {
+		return nil
 	}
-	root := &iNode{main: &node{cNode: &cNode{}}}
-	return makectrie(root, hashFactory, false)
+	return func(old V, exists bool) bool {
+		*allowed = cond(old, exists)
+		return *allowed
+	}
+}
+
+// updater computes the new value for a key from the value stored at the
+// linearization point of an update. Returning keep == false removes the key.
+type updater[V any] func(old V, exists bool) (value V, keep bool)
+
+// absent is the condition for insert-if-absent writes.
+func absent[V any](_ V, exists bool) bool {
+	return !exists
+}
+
+// New creates an empty Ctrie with string keys which uses the provided hasher
+// for key hashing. If nil is passed in, it will default to keyed SipHash-2-4
+// hashing.
+func newCtrie[V any](hashFactory hasher) *ctrie[string, V] {
+	return newKeyedCtrie[string, V](stringKeys(hashFactory))
 }
 
-func makectrie(root *iNode, hashFactory hasher, readOnly bool) *ctrie {
-	return &ctrie{
-		root:        root,
-		hashFactory: hashFactory,
-		readOnly:    readOnly,
+// newKeyedCtrie creates an empty Ctrie which hashes and compares keys with
+// the given keyer.
+func newKeyedCtrie[K, V any](keys keyer[K]) *ctrie[K, V] {
+	root := &iNode[K, V]{main: &node[K, V]{cNode: &cNode[K, V]{}}}
+	return makectrie(root, keys, false)
+}
+
+func makectrie[K, V any](root *iNode[K, V], keys keyer[K], readOnly bool) *ctrie[K, V] {
+	return &ctrie[K, V]{
+		root:     root,
+		keys:     keys,
+		readOnly: readOnly,
 	}
 }
 
 // Insert adds the key-value pair to the Ctrie, replacing the existing value if
 // the key already exists.
-func (c *ctrie) Insert(key []byte, value interface{}) {
+func (c *ctrie[K, V]) Insert(key K, value V) {
+	c.Swap(key, value)
+}
+
+// Swap adds the key-value pair to the Ctrie, replacing the existing value if
+// the key already exists. It returns the previous value and true if the key
+// existed.
+func (c *ctrie[K, V]) Swap(key K, value V) (V, bool) {
 	c.assertReadWrite()
-	c.insert(&entry{
+	old, exists := c.insert(&entry[K, V]{
 		Key:   key,
 		Value: value,
 		hash:  c.hash(key),
-	})
+	}, nil)
+	if !exists {
+		atomic.AddInt64(&c.length, 1)
+	}
+	return old, exists
+}
+
+// InsertIf adds the key-value pair to the Ctrie if the condition holds for the
+// value stored at the linearization point. It returns the previous value and
+// true if the key existed, whether or not the value was replaced.
+func (c *ctrie[K, V]) InsertIf(key K, value V, cond condition[V]) (V, bool) {
+	c.assertReadWrite()
+	var allowed bool
+	old, exists := c.insert(&entry[K, V]{
+		Key:   key,
+		Value: value,
+		hash:  c.hash(key),
+	}, cond.recorded(&allowed))
+	if !exists && allowed {
+		atomic.AddInt64(&c.length, 1)
+	}
+	return old, exists
+}
+
+// InsertIfAbsent adds the key-value pair to the Ctrie only if the key does
+// not already exist. The check and the insert share a single linearization
+// point, so of several concurrent callers for the same key exactly one
+// succeeds. It returns the existing value and true if the key was present,
+// or the given value and false if it was inserted.
+func (c *ctrie[K, V]) InsertIfAbsent(key K, value V) (V, bool) {
+	old, loaded := c.InsertIf(key, value, absent[V])
+	if loaded {
+		return old, true
+	}
+	return value, false
 }
 
 // Lookup returns the value for the associated key or returns false if the key
 // doesn't exist.
-func (c *ctrie) Lookup(key []byte) (interface{}, bool) {
-	return c.lookup(&entry{Key: key, hash: c.hash(key)})
+func (c *ctrie[K, V]) Lookup(key K) (V, bool) {
+	return c.lookup(&entry[K, V]{Key: key, hash: c.hash(key)})
 }
 
 // Remove deletes the value for the associated key, returning true if it was
 // removed or false if the entry doesn't exist.
-func (c *ctrie) Remove(key []byte) (interface{}, bool) {
+func (c *ctrie[K, V]) Remove(key K) (V, bool) {
 	c.assertReadWrite()
-	return c.remove(&entry{Key: key, hash: c.hash(key)})
+	old, exists := c.remove(&entry[K, V]{Key: key, hash: c.hash(key)}, nil)
+	if exists {
+		atomic.AddInt64(&c.length, -1)
+	}
+	return old, exists
 }
 
-// Snapshot returns a stable, point-in-time snapshot of the Ctrie.
-func (c *ctrie) Snapshot(mode IoMode) *ctrie {
+// Update atomically replaces the value for the associated key with the result
+// of fn, or removes the key if fn returns false. If the GCAS fails the update
+// is retried, so fn may be called more than once and should not have side
+// effects. It returns the resulting value and whether the key exists.
+func (c *ctrie[K, V]) Update(key K, fn updater[V]) (V, bool) {
+	c.assertReadWrite()
+	// The last call of fn is the one made at the linearization point of the
+	// successful attempt.
+	var existed bool
+	val, exists := c.update(&entry[K, V]{Key: key, hash: c.hash(key)}, func(old V, ok bool) (V, bool) {
+		existed = ok
+		return fn(old, ok)
+	})
+	switch {
+	case exists && !existed:
+		atomic.AddInt64(&c.length, 1)
+	case !exists && existed:
+		atomic.AddInt64(&c.length, -1)
+	}
+	return val, exists
+}
+
+// RemoveIf deletes the value for the associated key if the condition holds
+// for the value stored at the linearization point. It returns the value found
+// and true if the key existed, whether or not it was removed.
+func (c *ctrie[K, V]) RemoveIf(key K, cond condition[V]) (V, bool) {
+	c.assertReadWrite()
+	var allowed bool
+	old, exists := c.remove(&entry[K, V]{Key: key, hash: c.hash(key)}, cond.recorded(&allowed))
+	if exists && allowed {
+		atomic.AddInt64(&c.length, -1)
+	}
+	return old, exists
+}
+
+// Snapshot returns a stable, point-in-time snapshot of the Ctrie. The root of
+// a read-only snapshot never changes, so it is its own read-only snapshot,
+// and a read-write one copies its root without an RDCSS. Otherwise the
+// snapshot is built from the root the RDCSS replaced: the root read after it
+// is the one writers to the Ctrie go on to change in place.
+func (c *ctrie[K, V]) Snapshot(mode IoMode) *ctrie[K, V] {
+	if c.readOnly {
+		if mode == ReadOnly {
+			return c
+		}
+		snapshot := makectrie(c.readRoot().copyToGen(&generation{}, c), c.keys, false)
+		snapshot.length = int64(c.Size())
+		return snapshot
+	}
 	if mode != ReadOnly {
 		for {
 			root := c.readRoot()
 			main := gcasRead(root, c)
 			if c.rdcssRoot(root, main, root.copyToGen(&generation{}, c)) {
-				return makectrie(c.readRoot().copyToGen(&generation{}, c), c.hashFactory, c.readOnly)
+				snapshot := makectrie(root.copyToGen(&generation{}, c), c.keys, c.readOnly)
+				snapshot.length = atomic.LoadInt64(&c.length)
+				return snapshot
 			}
 		}
 	}
-	if c.readOnly {
-		return c
-	}
 	for {
 		root := c.readRoot()
 		main := gcasRead(root, c)
 		if c.rdcssRoot(root, main, root.copyToGen(&generation{}, c)) {
-			return makectrie(c.readRoot(), c.hashFactory, true)
+			return makectrie(root, c.keys, true)
 		}
 	}
 
@@ -118,15 +246,17 @@ func (c *ctrie) Snapshot(mode IoMode) *ctrie {
 // }
 
 // Clear removes all keys from the Ctrie.
-func (c *ctrie) Clear() {
+func (c *ctrie[K, V]) Clear() {
+	c.assertReadWrite()
 	for {
 		root := c.readRoot()
 		gen := &generation{}
-		newRoot := &iNode{
-			main: &node{cNode: &cNode{array: make([]branch, 0), gen: gen}},
+		newRoot := &iNode[K, V]{
+			main: &node[K, V]{cNode: &cNode[K, V]{array: make([]branch, 0), gen: gen}},
 			gen:  gen,
 		}
 		if c.rdcssRoot(root, gcasRead(root, c), newRoot) {
+			atomic.StoreInt64(&c.length, 0)
 			return
 		}
 	}
@@ -136,8 +266,8 @@ func (c *ctrie) Clear() {
 // cancel channel is provided, closing it will terminate and close the iterator
 // channel. Note that if a cancel channel is not used and not every entry is
 // read from the iterator, a goroutine will leak.
-func (c *ctrie) Iterate(cancel <-chan struct{}) <-chan *entry {
-	ch := make(chan *entry)
+func (c *ctrie[K, V]) Iterate(cancel <-chan struct{}) <-chan *entry[K, V] {
+	ch := make(chan *entry[K, V])
 	snapshot := c.Snapshot(ReadOnly)
 	go func() {
 		snapshot.traverse(snapshot.readRoot(), ch, cancel)
@@ -146,16 +276,26 @@ func (c *ctrie) Iterate(cancel <-chan struct{}) <-chan *entry {
 	return ch
 }
 
-// Size returns the number of keys in the Ctrie.
-func (c *ctrie) Size() uint {
-	// TODO: The size operation can be optimized further by caching the size
-	// information in main nodes of a read-only Ctrie – this reduces the
-	// amortized complexity of the size operation to O(1) because the size
-	// computation is amortized across the update operations that occurred
-	// since the last snapshot.
-	size := uint(0)
-	for _ = range c.Iterate(nil) {
-		size++
+// Size returns the number of keys in the Ctrie. The size is computed on a
+// read-only snapshot, whose main nodes cache the size of the subtrie below
+// them. Subtries left unchanged since a previous call are shared with its
+// snapshot, so the amortized complexity of Size is O(1): only the updates
+// since the last snapshot are counted again.
+func (c *ctrie[K, V]) Size() uint {
+	snapshot := c.Snapshot(ReadOnly)
+	return snapshot.cachedSize(snapshot.readRoot())
+}
+
+// Len returns the number of keys in the Ctrie as counted by completed inserts
+// and removes. Unlike Size it does not take a snapshot, but it is only
+// approximate while writes are in flight. For read-only snapshots Len is
+// exact and equal to Size.
+func (c *ctrie[K, V]) Len() uint {
+	if c.readOnly {
+		return c.Size()
+	}
+	if n := atomic.LoadInt64(&c.length); n > 0 {
+		return uint(n)
 	}
-	return size
+	return 0
 }
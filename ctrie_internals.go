@@ -1,22 +1,21 @@
 package sled
 
 import (
-	"bytes"
 	"sync/atomic"
 	"unsafe"
 )
 
-func (c *ctrie) traverse(i *iNode, ch chan<- *entry, cancel <-chan struct{}) error {
+func (c *ctrie[K, V]) traverse(i *iNode[K, V], ch chan<- *entry[K, V], cancel <-chan struct{}) error {
 	main := gcasRead(i, c)
 	switch {
 	case main.cNode != nil:
 		for _, br := range main.cNode.array {
 			switch b := br.(type) {
-			case *iNode:
+			case *iNode[K, V]:
 				if err := c.traverse(b, ch, cancel); err != nil {
 					return err
 				}
-			case *sNode:
+			case *sNode[K, V]:
 				select {
 				case ch <- b.entry:
 				case <-cancel:
@@ -24,12 +23,20 @@ func (c *ctrie) traverse(i *iNode, ch chan<- *entry, cancel <-chan struct{}) err
 				}
 			}
 		}
+	case main.tNode != nil:
+		// An entombed S-node is still part of the trie until it is
+		// compressed into its parent.
+		select {
+		case ch <- main.tNode.entry:
+		case <-cancel:
+			return ErrCanceled{}
+		}
 	case main.lNode != nil:
 		for _, e := range main.lNode.Map(func(sn interface{}) interface{} {
-			return sn.(*sNode).entry
+			return sn.(*sNode[K, V]).entry
 		}) {
 			select {
-			case ch <- e.(*entry):
+			case ch <- e.(*entry[K, V]):
 			case <-cancel:
 				return ErrCanceled{}
 			}
@@ -38,20 +45,60 @@ func (c *ctrie) traverse(i *iNode, ch chan<- *entry, cancel <-chan struct{}) err
 	return nil
 }
 
-func (c *ctrie) assertReadWrite() {
+// cachedSize returns the number of keys below the I-node and caches it on its
+// main node. It must only be used on read-only Ctries, where main nodes are
+// never replaced, so the count below a main node never changes.
+func (c *ctrie[K, V]) cachedSize(i *iNode[K, V]) uint {
+	main := gcasRead(i, c)
+	if s := atomic.LoadUint64(&main.size); s != 0 {
+		return uint(s - 1)
+	}
+	size := uint(0)
+	switch {
+	case main.cNode != nil:
+		for _, br := range main.cNode.array {
+			switch b := br.(type) {
+			case *iNode[K, V]:
+				size += c.cachedSize(b)
+			case *sNode[K, V]:
+				size++
+			}
+		}
+	case main.tNode != nil:
+		size = 1
+	case main.lNode != nil:
+		size = main.lNode.length()
+	}
+	atomic.StoreUint64(&main.size, uint64(size)+1)
+	return size
+}
+
+// writable returns ErrReadOnly if the Ctrie is a read-only snapshot.
+func (c *ctrie[K, V]) writable() error {
 	if c.readOnly {
-		panic("Cannot modify read-only snapshot")
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// assertReadWrite panics with ErrReadOnly if the Ctrie is a read-only
+// snapshot.
+func (c *ctrie[K, V]) assertReadWrite() {
+	if err := c.writable(); err != nil {
+		panic(err)
 	}
 }
 
-func (c *ctrie) insert(entry *entry) {
+func (c *ctrie[K, V]) insert(entry *entry[K, V], cond condition[V]) (V, bool) {
 	root := c.readRoot()
-	if !c.iinsert(root, entry, 0, nil, root.gen) {
-		c.insert(entry)
+	result, exists, ok := c.iinsert(root, entry, 0, nil, root.gen, cond)
+	if !ok {
+		return c.insert(entry, cond)
 	}
+	return result, exists
 }
 
-func (c *ctrie) lookup(entry *entry) (interface{}, bool) {
+func (c *ctrie[K, V]) lookup(entry *entry[K, V]) (V, bool) {
 	root := c.readRoot()
 	result, exists, ok := c.ilookup(root, entry, 0, nil, root.gen)
 	for !ok {
@@ -60,51 +107,68 @@ func (c *ctrie) lookup(entry *entry) (interface{}, bool) {
 	return result, exists
 }
 
-func (c *ctrie) remove(entry *entry) (interface{}, bool) {
+func (c *ctrie[K, V]) remove(entry *entry[K, V], cond condition[V]) (V, bool) {
 	root := c.readRoot()
-	result, exists, ok := c.iremove(root, entry, 0, nil, root.gen)
+	result, exists, ok := c.iremove(root, entry, 0, nil, root.gen, cond)
 	for !ok {
-		return c.remove(entry)
+		return c.remove(entry, cond)
 	}
 	return result, exists
 }
 
-func (c *ctrie) hash(k []byte) uint64 {
-	hasher := c.hashFactory()
-	hasher.Write(k)
-	return hasher.Sum64()
+func (c *ctrie[K, V]) update(entry *entry[K, V], fn updater[V]) (V, bool) {
+	root := c.readRoot()
+	result, exists, ok := c.iupdate(root, entry, 0, nil, root.gen, fn)
+	if !ok {
+		return c.update(entry, fn)
+	}
+	return result, exists
+}
+
+func (c *ctrie[K, V]) hash(k K) uint64 {
+	return c.keys.hash(k)
 }
 
 // If the relevant bit is not in the bitmap, then a copy of the
 // cNode with the new entry is created. The linearization point is
 // a successful CAS.
-func (c *ctrie) nobit(cn *cNode, gen *generation, entry *entry, lev uint) (uint64, *node) {
+func (c *ctrie[K, V]) nobit(cn *cNode[K, V], gen *generation, entry *entry[K, V], lev uint) (uint64, *node[K, V]) {
 	flag, pos := flagPos(entry.hash, lev, cn.bmp)
 	if cn.bmp&flag != 0 {
 		return pos, nil
 	}
 	rn := cn.renewif(gen, c)
-	return pos, &node{cNode: rn.inserted(pos, flag, &sNode{entry}, gen)}
+	return pos, &node[K, V]{cNode: rn.inserted(pos, flag, &sNode[K, V]{entry}, gen)}
 }
 
-func (c *ctrie) branchinode(main *node, in *iNode, i *iNode, entry *entry, lev uint, parent *iNode, startGen *generation) bool {
+func (c *ctrie[K, V]) branchinode(main *node[K, V], in *iNode[K, V], i *iNode[K, V], entry *entry[K, V], lev uint, parent *iNode[K, V], startGen *generation, cond condition[V]) (V, bool, bool) {
+	var zero V
 	// If the branch is an I-node, then iinsert is called recursively.
 	if startGen == in.gen {
-		return c.iinsert(in, entry, lev+w, i, startGen)
+		return c.iinsert(in, entry, lev+w, i, startGen, cond)
 	}
-	if gcas(i, main, &node{cNode: main.cNode.renewed(startGen, c)}, c) {
-		return c.iinsert(i, entry, lev, parent, startGen)
+	if gcas(i, main, &node[K, V]{cNode: main.cNode.renewed(startGen, c)}, c) {
+		return c.iinsert(i, entry, lev, parent, startGen, cond)
 	}
-	return false
+	return zero, false, false
 }
 
-func (c *ctrie) branchsnode(main *node, sn *sNode, i *iNode, entry *entry, lev uint, pos uint64) bool {
-	if bytes.Equal(sn.Key, entry.Key) {
+func (c *ctrie[K, V]) branchsnode(main *node[K, V], sn *sNode[K, V], i *iNode[K, V], entry *entry[K, V], lev uint, pos uint64, cond condition[V]) (V, bool, bool) {
+	var zero V
+	if c.keys.equal(sn.Key, entry.Key) {
+		// The key is already present, so a conditional insert is decided
+		// against the value in the S-node read at the linearization point.
+		if !cond.allows(sn.Value, true) {
+			return sn.Value, true, true
+		}
 		// If the key in the S-node is equal to the key being inserted,
 		// then the C-node is replaced with its updated version with a new
 		// S-node. The linearization point is a successful CAS.
-		ncn := &node{cNode: main.cNode.updated(pos, &sNode{entry}, i.gen)}
-		return gcas(i, main, ncn, c)
+		ncn := &node[K, V]{cNode: main.cNode.updated(pos, &sNode[K, V]{entry}, i.gen)}
+		return sn.Value, true, gcas(i, main, ncn, c)
+	}
+	if !cond.allows(zero, false) {
+		return zero, false, true
 	}
 	// If the branch is an S-node and its key is not equal to the
 	// key being inserted, then the Ctrie has to be extended with
@@ -114,54 +178,67 @@ func (c *ctrie) branchsnode(main *node, sn *sNode, i *iNode, entry *entry, lev u
 	// main node pointing to a C-node with both keys. The
 	// linearization point is a successful CAS.
 	rn := main.cNode.renewif(i.gen, c)
-	nsn := &sNode{entry}
-	nin := &iNode{main: newNode(sn, sn.hash, nsn, nsn.hash, lev+w, i.gen), gen: i.gen}
-	ncn := &node{cNode: rn.updated(pos, nin, i.gen)}
-	return gcas(i, main, ncn, c)
+	nsn := &sNode[K, V]{entry}
+	nin := &iNode[K, V]{main: newNode(sn, sn.hash, nsn, nsn.hash, lev+w, i.gen), gen: i.gen}
+	ncn := &node[K, V]{cNode: rn.updated(pos, nin, i.gen)}
+	return zero, false, gcas(i, main, ncn, c)
 }
 
-func (c *ctrie) cinsert(main *node, i *iNode, entry *entry, lev uint, parent *iNode, startGen *generation) bool {
-	var ncn *node
+func (c *ctrie[K, V]) cinsert(main *node[K, V], i *iNode[K, V], entry *entry[K, V], lev uint, parent *iNode[K, V], startGen *generation, cond condition[V]) (V, bool, bool) {
+	var zero V
+	var ncn *node[K, V]
 	var pos uint64
 	if pos, ncn = c.nobit(main.cNode, i.gen, entry, lev); ncn != nil {
-		return gcas(i, main, ncn, c)
+		if !cond.allows(zero, false) {
+			return zero, false, true
+		}
+		return zero, false, gcas(i, main, ncn, c)
 	}
 	// If the relevant bit is present in the bitmap, then its corresponding
 	// branch is read from the array.
 	branch := main.cNode.array[pos]
 	switch n := branch.(type) {
-	case *iNode:
-		return c.branchinode(main, n, i, entry, lev, parent, startGen)
-	case *sNode:
-		return c.branchsnode(main, n, i, entry, lev, pos)
+	case *iNode[K, V]:
+		return c.branchinode(main, n, i, entry, lev, parent, startGen, cond)
+	case *sNode[K, V]:
+		return c.branchsnode(main, n, i, entry, lev, pos, cond)
 	default:
 		panic("Ctrie is in an invalid state")
 	}
 }
 
-// iinsert attempts to insert the entry into the Ctrie. If false is returned,
-// the operation should be retried.
-func (c *ctrie) iinsert(i *iNode, entry *entry, lev uint, parent *iNode, startGen *generation) bool {
+// iinsert attempts to insert the entry into the Ctrie. The first two return
+// values are the previous value and whether or not the key was contained in
+// the Ctrie. If a condition is given, the entry is only inserted when the
+// condition holds for the previous value. The last bool indicates if the
+// operation succeeded. False means it should be retried.
+func (c *ctrie[K, V]) iinsert(i *iNode[K, V], entry *entry[K, V], lev uint, parent *iNode[K, V], startGen *generation, cond condition[V]) (V, bool, bool) {
+	var zero V
 	// Linearization point.
 	main := gcasRead(i, c)
 	switch {
 	case main.cNode != nil:
-		return c.cinsert(main, i, entry, lev, parent, startGen)
+		return c.cinsert(main, i, entry, lev, parent, startGen, cond)
 	case main.tNode != nil:
 		clean(parent, lev-w, c)
 	case main.lNode != nil:
-		return gcas(i, main, &node{lNode: main.lNode.inserted(entry)}, c)
+		val, ok := main.lNode.lookup(entry, c.keys)
+		if !cond.allows(val, ok) {
+			return val, ok, true
+		}
+		return val, ok, gcas(i, main, &node[K, V]{lNode: main.lNode.inserted(entry, c.keys)}, c)
 	default:
 		panic("Ctrie is in an invalid state")
 	}
-	return false
+	return zero, false, false
 }
 
 // ilookup attempts to fetch the entry from the Ctrie. The first two return
 // values are the entry value and whether or not the entry was contained in the
 // Ctrie. The last bool indicates if the operation succeeded. False means it
 // should be retried.
-func (c *ctrie) ilookup(i *iNode, entry *entry, lev uint, parent *iNode, startGen *generation) (interface{}, bool, bool) {
+func (c *ctrie[K, V]) ilookup(i *iNode[K, V], entry *entry[K, V], lev uint, parent *iNode[K, V], startGen *generation) (V, bool, bool) {
+	var zero V
 	// Linearization point.
 	main := gcasRead(i, c)
 	switch {
@@ -171,33 +248,33 @@ func (c *ctrie) ilookup(i *iNode, entry *entry, lev uint, parent *iNode, startGe
 		if cn.bmp&flag == 0 {
 			// If the bitmap does not contain the relevant bit, a key with the
 			// required hashcode prefix is not present in the trie.
-			return nil, false, true
+			return zero, false, true
 		}
 		// Otherwise, the relevant branch at index pos is read from the array.
 		branch := cn.array[pos]
 		switch branch.(type) {
-		case *iNode:
+		case *iNode[K, V]:
 			// If the branch is an I-node, the ilookup procedure is called
 			// recursively at the next level.
-			in := branch.(*iNode)
+			in := branch.(*iNode[K, V])
 			if c.readOnly || startGen == in.gen {
 				return c.ilookup(in, entry, lev+w, i, startGen)
 			}
-			if gcas(i, main, &node{cNode: cn.renewed(startGen, c)}, c) {
+			if gcas(i, main, &node[K, V]{cNode: cn.renewed(startGen, c)}, c) {
 				return c.ilookup(i, entry, lev, parent, startGen)
 			}
-			return nil, false, false
-		case *sNode:
+			return zero, false, false
+		case *sNode[K, V]:
 			// If the branch is an S-node, then the key within the S-node is
 			// compared with the key being searched – these two keys have the
 			// same hashcode prefixes, but they need not be equal. If they are
 			// equal, the corresponding value from the S-node is
 			// returned and a NOTFOUND value otherwise.
-			sn := branch.(*sNode)
-			if bytes.Equal(sn.Key, entry.Key) {
+			sn := branch.(*sNode[K, V])
+			if c.keys.equal(sn.Key, entry.Key) {
 				return sn.Value, true, true
 			}
-			return nil, false, true
+			return zero, false, true
 		default:
 			panic("Ctrie is in an invalid state")
 		}
@@ -206,7 +283,7 @@ func (c *ctrie) ilookup(i *iNode, entry *entry, lev uint, parent *iNode, startGe
 	case main.lNode != nil:
 		// Hash collisions are handled using L-nodes, which are essentially
 		// persistent linked lists.
-		val, ok := main.lNode.lookup(entry)
+		val, ok := main.lNode.lookup(entry, c.keys)
 		return val, ok, true
 	default:
 		panic("Ctrie is in an invalid state")
@@ -215,9 +292,11 @@ func (c *ctrie) ilookup(i *iNode, entry *entry, lev uint, parent *iNode, startGe
 
 // iremove attempts to remove the entry from the Ctrie. The first two return
 // values are the entry value and whether or not the entry was contained in the
-// Ctrie. The last bool indicates if the operation succeeded. False means it
-// should be retried.
-func (c *ctrie) iremove(i *iNode, entry *entry, lev uint, parent *iNode, startGen *generation) (interface{}, bool, bool) {
+// Ctrie. If a condition is given, the entry is only removed when the condition
+// holds for its value. The last bool indicates if the operation succeeded.
+// False means it should be retried.
+func (c *ctrie[K, V]) iremove(i *iNode[K, V], entry *entry[K, V], lev uint, parent *iNode[K, V], startGen *generation, cond condition[V]) (V, bool, bool) {
+	var zero V
 	// Linearization point.
 	main := gcasRead(i, c)
 	switch {
@@ -227,29 +306,32 @@ func (c *ctrie) iremove(i *iNode, entry *entry, lev uint, parent *iNode, startGe
 		if cn.bmp&flag == 0 {
 			// If the bitmap does not contain the relevant bit, a key with the
 			// required hashcode prefix is not present in the trie.
-			return nil, false, true
+			return zero, false, true
 		}
 		// Otherwise, the relevant branch at index pos is read from the array.
 		branch := cn.array[pos]
 		switch branch.(type) {
-		case *iNode:
+		case *iNode[K, V]:
 			// If the branch is an I-node, the iremove procedure is called
 			// recursively at the next level.
-			in := branch.(*iNode)
+			in := branch.(*iNode[K, V])
 			if startGen == in.gen {
-				return c.iremove(in, entry, lev+w, i, startGen)
+				return c.iremove(in, entry, lev+w, i, startGen, cond)
 			}
-			if gcas(i, main, &node{cNode: cn.renewed(startGen, c)}, c) {
-				return c.iremove(i, entry, lev, parent, startGen)
+			if gcas(i, main, &node[K, V]{cNode: cn.renewed(startGen, c)}, c) {
+				return c.iremove(i, entry, lev, parent, startGen, cond)
 			}
-			return nil, false, false
-		case *sNode:
+			return zero, false, false
+		case *sNode[K, V]:
 			// If the branch is an S-node, its key is compared against the key
 			// being removed.
-			sn := branch.(*sNode)
-			if !bytes.Equal(sn.Key, entry.Key) {
+			sn := branch.(*sNode[K, V])
+			if !c.keys.equal(sn.Key, entry.Key) {
 				// If the keys are not equal, the NOTFOUND value is returned.
-				return nil, false, true
+				return zero, false, true
+			}
+			if !cond.allows(sn.Value, true) {
+				return sn.Value, true, true
 			}
 			//  If the keys are equal, a copy of the current node without the
 			//  S-node is created. The contraction of the copy is then created
@@ -257,34 +339,121 @@ func (c *ctrie) iremove(i *iNode, entry *entry, lev uint, parent *iNode, startGe
 			//  substitute the old C-node with the copied C-node, thus removing
 			//  the S-node with the given key from the trie – this is the
 			//  linearization point
-			ncn := cn.removed(pos, flag, i.gen)
-			cntr := toContracted(ncn, lev)
-			if gcas(i, main, cntr, c) {
-				if parent != nil {
-					main = gcasRead(i, c)
-					if main.tNode != nil {
-						cleanParent(parent, i, entry.hash, lev-w, c, startGen)
-					}
-				}
+			if c.removeBranch(main, i, pos, flag, entry.hash, lev, parent, startGen) {
 				return sn.Value, true, true
 			}
-			return nil, false, false
+			return zero, false, false
 		default:
 			panic("Ctrie is in an invalid state")
 		}
 	case main.tNode != nil:
 		clean(parent, lev-w, c)
-		return nil, false, false
+		return zero, false, false
 	case main.lNode != nil:
-		nln := &node{lNode: main.lNode.removed(entry)}
-		if nln.lNode.length() == 1 {
-			nln = entomb(nln.lNode.entry())
+		val, ok := main.lNode.lookup(entry, c.keys)
+		if !ok {
+			return zero, false, true
 		}
-		if gcas(i, main, nln, c) {
-			val, ok := main.lNode.lookup(entry)
-			return val, ok, true
+		if !cond.allows(val, true) {
+			return val, true, true
+		}
+		if gcas(i, main, main.lNode.removedNode(entry, c.keys), c) {
+			return val, true, true
+		}
+		return zero, false, false
+	default:
+		panic("Ctrie is in an invalid state")
+	}
+}
+
+// removeBranch replaces the C-node of i with a contracted copy that does not
+// contain the S-node at pos, and cleans up the parent if i was entombed. It
+// returns false if the GCAS failed.
+func (c *ctrie[K, V]) removeBranch(main *node[K, V], i *iNode[K, V], pos, flag, hc uint64, lev uint, parent *iNode[K, V], startGen *generation) bool {
+	ncn := main.cNode.removed(pos, flag, i.gen)
+	cntr := toContracted(ncn, lev)
+	if !gcas(i, main, cntr, c) {
+		return false
+	}
+	if parent != nil {
+		main = gcasRead(i, c)
+		if main.tNode != nil {
+			cleanParent(parent, i, hc, lev-w, c, startGen)
+		}
+	}
+	return true
+}
+
+// iupdate applies fn to the value stored for the entry's key, or to nil if
+// the key is absent, and stores the result. If fn returns keep == false the
+// key is removed instead. fn is called at the linearization point of each
+// attempt. The first two return values are the resulting value and whether
+// or not the key is contained in the Ctrie afterwards. The last bool
+// indicates if the operation succeeded. False means it should be retried.
+func (c *ctrie[K, V]) iupdate(i *iNode[K, V], entry *entry[K, V], lev uint, parent *iNode[K, V], startGen *generation, fn updater[V]) (V, bool, bool) {
+	var zero V
+	// Linearization point.
+	main := gcasRead(i, c)
+	switch {
+	case main.cNode != nil:
+		cn := main.cNode
+		flag, pos := flagPos(entry.hash, lev, cn.bmp)
+		if cn.bmp&flag == 0 {
+			// The key is absent, so fn decides whether it is inserted.
+			nv, keep := fn(zero, false)
+			if !keep {
+				return zero, false, true
+			}
+			ne := entry.withValue(nv)
+			ncn := &node[K, V]{cNode: cn.renewif(i.gen, c).inserted(pos, flag, &sNode[K, V]{ne}, i.gen)}
+			return nv, true, gcas(i, main, ncn, c)
+		}
+		switch b := cn.array[pos].(type) {
+		case *iNode[K, V]:
+			if startGen == b.gen {
+				return c.iupdate(b, entry, lev+w, i, startGen, fn)
+			}
+			if gcas(i, main, &node[K, V]{cNode: cn.renewed(startGen, c)}, c) {
+				return c.iupdate(i, entry, lev, parent, startGen, fn)
+			}
+			return zero, false, false
+		case *sNode[K, V]:
+			if c.keys.equal(b.Key, entry.Key) {
+				nv, keep := fn(b.Value, true)
+				if !keep {
+					// Removal goes through the same contraction as iremove.
+					return zero, false, c.removeBranch(main, i, pos, flag, entry.hash, lev, parent, startGen)
+				}
+				ne := entry.withValue(nv)
+				ncn := &node[K, V]{cNode: cn.updated(pos, &sNode[K, V]{ne}, i.gen)}
+				return nv, true, gcas(i, main, ncn, c)
+			}
+			nv, keep := fn(zero, false)
+			if !keep {
+				return zero, false, true
+			}
+			nsn := &sNode[K, V]{entry.withValue(nv)}
+			rn := cn.renewif(i.gen, c)
+			nin := &iNode[K, V]{main: newNode(b, b.hash, nsn, nsn.hash, lev+w, i.gen), gen: i.gen}
+			ncn := &node[K, V]{cNode: rn.updated(pos, nin, i.gen)}
+			return nv, true, gcas(i, main, ncn, c)
+		default:
+			panic("Ctrie is in an invalid state")
 		}
-		return nil, false, true
+	case main.tNode != nil:
+		clean(parent, lev-w, c)
+		return zero, false, false
+	case main.lNode != nil:
+		val, exists := main.lNode.lookup(entry, c.keys)
+		nv, keep := fn(val, exists)
+		switch {
+		case keep:
+			ne := entry.withValue(nv)
+			return nv, true, gcas(i, main, &node[K, V]{lNode: main.lNode.inserted(ne, c.keys)}, c)
+		case exists:
+			return zero, false, gcas(i, main, main.lNode.removedNode(entry, c.keys), c)
+		}
+		return zero, false, true
 	default:
 		panic("Ctrie is in an invalid state")
 	}
@@ -295,7 +464,7 @@ func (c *ctrie) iremove(i *iNode, entry *entry, lev uint, parent *iNode, startGe
 // readRoot performs a linearizable read of the Ctrie root. This operation is
 // prioritized so that if another thread performs a GCAS on the root, a
 // deadlock does not occur.
-func (c *ctrie) readRoot() *iNode {
+func (c *ctrie[K, V]) readRoot() *iNode[K, V] {
 	r := alin(&c.root)
 	if r.rdcss != nil {
 		return c.rdcssComplete()
@@ -306,8 +475,8 @@ func (c *ctrie) readRoot() *iNode {
 // rdcssRoot performs a RDCSS on the Ctrie root. This is used to create a
 // snapshot of the Ctrie by copying the root I-node and setting it to a new
 // generation.
-func (c *ctrie) rdcssRoot(old *iNode, expected *node, nv *iNode) bool {
-	desc := &iNode{rdcss: newRdcssDescriptor(old, expected, nv)}
+func (c *ctrie[K, V]) rdcssRoot(old *iNode[K, V], expected *node[K, V], nv *iNode[K, V]) bool {
+	desc := &iNode[K, V]{rdcss: newRdcssDescriptor(old, expected, nv)}
 	if c.casRoot(old, desc) {
 		c.rdcssComplete()
 		// return atomic.LoadInt32(&desc.rdcss.committed) == 1
@@ -317,9 +486,9 @@ func (c *ctrie) rdcssRoot(old *iNode, expected *node, nv *iNode) bool {
 }
 
 // rdcssComplete commits the RDCSS operation.
-func (c *ctrie) rdcssComplete() *iNode {
+func (c *ctrie[K, V]) rdcssComplete() *iNode[K, V] {
 	for {
-		r := (*iNode)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&c.root))))
+		r := (*iNode[K, V])(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&c.root))))
 		if r.rdcss == nil {
 			return r
 		}
@@ -347,10 +516,10 @@ func (c *ctrie) rdcssComplete() *iNode {
 	}
 }
 
-func (c *ctrie) rdcssCompleteAbort() *iNode {
+func (c *ctrie[K, V]) rdcssCompleteAbort() *iNode[K, V] {
 	for {
 
-		r := (*iNode)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&c.root))))
+		r := (*iNode[K, V])(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&c.root))))
 		if r.rdcss == nil {
 			return r
 		}
@@ -362,8 +531,12 @@ func (c *ctrie) rdcssCompleteAbort() *iNode {
 	}
 }
 
-// casRoot performs a CAS on the Ctrie root.
-func (c *ctrie) casRoot(ov, nv *iNode) bool {
-	c.assertReadWrite()
+// casRoot performs a CAS on the Ctrie root. The root of a read-only snapshot
+// never changes, so the CAS fails. Snapshot and Clear never start an RDCSS
+// on one, so its root never holds a descriptor for rdcssComplete to retry.
+func (c *ctrie[K, V]) casRoot(ov, nv *iNode[K, V]) bool {
+	if c.readOnly {
+		return false
+	}
 	return casRoot(&c.root, ov, nv)
 }
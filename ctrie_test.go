@@ -5,6 +5,7 @@ import (
 	"hash/fnv"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,54 +14,54 @@ import (
 
 func TestCtrie(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 
-	_, ok := ctrie.Lookup([]byte("foo"))
+	_, ok := ctrie.Lookup("foo")
 	assert.False(ok)
 
-	ctrie.Insert([]byte("foo"), "bar")
-	val, ok := ctrie.Lookup([]byte("foo"))
+	ctrie.Insert("foo", "bar")
+	val, ok := ctrie.Lookup("foo")
 	assert.True(ok)
 	assert.Equal("bar", val)
 
-	ctrie.Insert([]byte("fooooo"), "baz")
-	val, ok = ctrie.Lookup([]byte("foo"))
+	ctrie.Insert("fooooo", "baz")
+	val, ok = ctrie.Lookup("foo")
 	assert.True(ok)
 	assert.Equal("bar", val)
-	val, ok = ctrie.Lookup([]byte("fooooo"))
+	val, ok = ctrie.Lookup("fooooo")
 	assert.True(ok)
 	assert.Equal("baz", val)
 
 	for i := 0; i < 100; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), "blah")
+		ctrie.Insert(strconv.Itoa(i), "blah")
 	}
 	for i := 0; i < 100; i++ {
-		val, ok = ctrie.Lookup([]byte(strconv.Itoa(i)))
+		val, ok = ctrie.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal("blah", val)
 	}
 
-	val, ok = ctrie.Lookup([]byte("foo"))
+	val, ok = ctrie.Lookup("foo")
 	assert.True(ok)
 	assert.Equal("bar", val)
-	ctrie.Insert([]byte("foo"), "qux")
-	val, ok = ctrie.Lookup([]byte("foo"))
+	ctrie.Insert("foo", "qux")
+	val, ok = ctrie.Lookup("foo")
 	assert.True(ok)
 	assert.Equal("qux", val)
 
-	val, ok = ctrie.Remove([]byte("foo"))
+	val, ok = ctrie.Remove("foo")
 	assert.True(ok)
 	assert.Equal("qux", val)
 
-	_, ok = ctrie.Remove([]byte("foo"))
+	_, ok = ctrie.Remove("foo")
 	assert.False(ok)
 
-	val, ok = ctrie.Remove([]byte("fooooo"))
+	val, ok = ctrie.Remove("fooooo")
 	assert.True(ok)
 	assert.Equal("baz", val)
 
 	for i := 0; i < 100; i++ {
-		ctrie.Remove([]byte(strconv.Itoa(i)))
+		ctrie.Remove(strconv.Itoa(i))
 	}
 }
 
@@ -76,47 +77,219 @@ func mockHashFactory() hash.Hash64 {
 	return &mockHash64{fnv.New64a()}
 }
 
+func TestSeededKeys(t *testing.T) {
+	assert := assert.New(t)
+	plain := stringKeys(nil)
+	a := seededStringKeys(nil, []byte{1})
+	b := seededStringKeys(nil, []byte{2})
+	assert.NotEqual(plain.hash("foo"), a.hash("foo"))
+	assert.NotEqual(a.hash("foo"), b.hash("foo"))
+	assert.Equal(a.hash("foo"), seededStringKeys(nil, []byte{1}).hash("foo"))
+}
+
 func TestInsertLNode(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(mockHashFactory)
+	ctrie := newCtrie[interface{}](mockHashFactory)
 
 	for i := 0; i < 10; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 
 	for i := 0; i < 10; i++ {
-		val, ok := ctrie.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := ctrie.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
-	_, ok := ctrie.Lookup([]byte("11"))
+	_, ok := ctrie.Lookup("11")
 	assert.False(ok)
 
 	for i := 0; i < 10; i++ {
-		val, ok := ctrie.Remove([]byte(strconv.Itoa(i)))
+		val, ok := ctrie.Remove(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
 }
 
+func TestInsertIfAbsent(t *testing.T) {
+	assert := assert.New(t)
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		for i := 0; i < 10; i++ {
+			val, loaded := ctrie.InsertIfAbsent(strconv.Itoa(i), i)
+			assert.False(loaded)
+			assert.Equal(i, val)
+		}
+		for i := 0; i < 10; i++ {
+			val, loaded := ctrie.InsertIfAbsent(strconv.Itoa(i), -1)
+			assert.True(loaded)
+			assert.Equal(i, val)
+		}
+		assert.Equal(uint(10), ctrie.Size())
+	}
+}
+
+func TestInsertIfAbsentConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		var wg sync.WaitGroup
+		var wins int32
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					if _, loaded := ctrie.InsertIfAbsent(strconv.Itoa(i), g); !loaded {
+						atomic.AddInt32(&wins, 1)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		assert.Equal(int32(10), wins)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	assert := assert.New(t)
+	is := func(want interface{}) condition[interface{}] {
+		return func(v interface{}, exists bool) bool {
+			return exists && v == want
+		}
+	}
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		for i := 0; i < 10; i++ {
+			ctrie.Insert(strconv.Itoa(i), i)
+		}
+
+		// Swap only succeeds against the current value.
+		old, ok := ctrie.InsertIf("3", 30, is(4))
+		assert.True(ok)
+		assert.Equal(3, old)
+		val, _ := ctrie.Lookup("3")
+		assert.Equal(3, val)
+		ctrie.InsertIf("3", 30, is(3))
+		val, _ = ctrie.Lookup("3")
+		assert.Equal(30, val)
+
+		// Missing keys never satisfy an exists condition.
+		_, ok = ctrie.InsertIf("11", 11, is(nil))
+		assert.False(ok)
+		_, ok = ctrie.Lookup("11")
+		assert.False(ok)
+
+		// Delete only succeeds against the current value.
+		ctrie.RemoveIf("5", is(6))
+		_, ok = ctrie.Lookup("5")
+		assert.True(ok)
+		val, ok = ctrie.RemoveIf("5", is(5))
+		assert.True(ok)
+		assert.Equal(5, val)
+		_, ok = ctrie.Lookup("5")
+		assert.False(ok)
+		assert.Equal(uint(9), ctrie.Size())
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	assert := assert.New(t)
+	incr := func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	}
+	drop := func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	}
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		for i := 0; i < 10; i++ {
+			val, ok := ctrie.Update(strconv.Itoa(i), incr)
+			assert.True(ok)
+			assert.Equal(1, val)
+		}
+		val, _ := ctrie.Update("3", incr)
+		assert.Equal(2, val)
+
+		// Dropping a missing key leaves it missing.
+		_, ok := ctrie.Update("11", drop)
+		assert.False(ok)
+		_, ok = ctrie.Update("3", drop)
+		assert.False(ok)
+		_, ok = ctrie.Lookup("3")
+		assert.False(ok)
+		assert.Equal(uint(9), ctrie.Size())
+
+		for i := 0; i < 10; i++ {
+			ctrie.Update(strconv.Itoa(i), drop)
+		}
+		assert.Equal(uint(0), ctrie.Size())
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < 200; n++ {
+					ctrie.Update(strconv.Itoa(n%4), func(old interface{}, exists bool) (interface{}, bool) {
+						if !exists {
+							return 1, true
+						}
+						return old.(int) + 1, true
+					})
+				}
+			}()
+		}
+		wg.Wait()
+		for i := 0; i < 4; i++ {
+			val, ok := ctrie.Lookup(strconv.Itoa(i))
+			assert.True(ok)
+			assert.Equal(400, val)
+		}
+	}
+}
+
+func TestLNodeReplace(t *testing.T) {
+	assert := assert.New(t)
+	ctrie := newCtrie[interface{}](mockHashFactory)
+	ctrie.Insert("foo", 1)
+	ctrie.Insert("bar", 2)
+	ctrie.Insert("foo", 3)
+	assert.Equal(uint(2), ctrie.Size())
+
+	val, ok := ctrie.Remove("foo")
+	assert.True(ok)
+	assert.Equal(3, val)
+	_, ok = ctrie.Lookup("foo")
+	assert.False(ok)
+}
+
 func TestInsertTNode(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 
 	for i := 0; i < 10000; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 
 	for i := 0; i < 5000; i++ {
-		ctrie.Remove([]byte(strconv.Itoa(i)))
+		ctrie.Remove(strconv.Itoa(i))
 	}
 
 	for i := 0; i < 10000; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 
 	for i := 0; i < 10000; i++ {
-		val, ok := ctrie.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := ctrie.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
@@ -124,20 +297,20 @@ func TestInsertTNode(t *testing.T) {
 
 func TestConcurrency(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		for i := 0; i < 10000; i++ {
-			ctrie.Insert([]byte(strconv.Itoa(i)), i)
+			ctrie.Insert(strconv.Itoa(i), i)
 		}
 		wg.Done()
 	}()
 
 	go func() {
 		for i := 0; i < 10000; i++ {
-			val, ok := ctrie.Lookup([]byte(strconv.Itoa(i)))
+			val, ok := ctrie.Lookup(strconv.Itoa(i))
 			if ok {
 				assert.Equal(i, val)
 			}
@@ -147,7 +320,7 @@ func TestConcurrency(t *testing.T) {
 
 	for i := 0; i < 10000; i++ {
 		time.Sleep(5)
-		ctrie.Remove([]byte(strconv.Itoa(i)))
+		ctrie.Remove(strconv.Itoa(i))
 	}
 
 	wg.Wait()
@@ -155,20 +328,20 @@ func TestConcurrency(t *testing.T) {
 
 func TestConcurrency2(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	var wg sync.WaitGroup
 	wg.Add(4)
 
 	go func() {
 		for i := 0; i < 10000; i++ {
-			ctrie.Insert([]byte(strconv.Itoa(i)), i)
+			ctrie.Insert(strconv.Itoa(i), i)
 		}
 		wg.Done()
 	}()
 
 	go func() {
 		for i := 0; i < 10000; i++ {
-			val, ok := ctrie.Lookup([]byte(strconv.Itoa(i)))
+			val, ok := ctrie.Lookup(strconv.Itoa(i))
 			if ok {
 				assert.Equal(i, val)
 			}
@@ -196,93 +369,119 @@ func TestConcurrency2(t *testing.T) {
 
 func TestSnapshot(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < 100; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 
 	snapshot := ctrie.Snapshot(ReadWrite)
 
 	// Ensure snapshot contains expected keys.
 	for i := 0; i < 100; i++ {
-		val, ok := snapshot.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := snapshot.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
 
 	for i := 0; i < 100; i++ {
-		ctrie.Remove([]byte(strconv.Itoa(i)))
+		ctrie.Remove(strconv.Itoa(i))
 	}
 
 	// Ensure snapshot was unaffected by removals.
 	for i := 0; i < 100; i++ {
-		val, ok := snapshot.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := snapshot.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
 
-	ctrie = newCtrie(nil)
+	ctrie = newCtrie[interface{}](nil)
 	for i := 0; i < 100; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 	snapshot = ctrie.Snapshot(ReadWrite)
 
 	// Ensure snapshot is mutable.
 	for i := 0; i < 100; i++ {
-		snapshot.Remove([]byte(strconv.Itoa(i)))
+		snapshot.Remove(strconv.Itoa(i))
 	}
-	snapshot.Insert([]byte("bat"), "man")
+	snapshot.Insert("bat", "man")
 
 	for i := 0; i < 100; i++ {
-		_, ok := snapshot.Lookup([]byte(strconv.Itoa(i)))
+		_, ok := snapshot.Lookup(strconv.Itoa(i))
 		assert.False(ok)
 	}
-	val, ok := snapshot.Lookup([]byte("bat"))
+	val, ok := snapshot.Lookup("bat")
 	assert.True(ok)
 	assert.Equal("man", val)
 
 	// Ensure original Ctrie was unaffected.
 	for i := 0; i < 100; i++ {
-		val, ok := ctrie.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := ctrie.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
-	_, ok = ctrie.Lookup([]byte("bat"))
+	_, ok = ctrie.Lookup("bat")
 	assert.False(ok)
 
 	snapshot = ctrie.Snapshot(ReadOnly)
 	for i := 0; i < 100; i++ {
-		val, ok := snapshot.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := snapshot.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
 
 	// Ensure read-only snapshots panic on writes.
-	defer func() {
-		assert.NotNil(recover())
-	}()
-	snapshot.Remove([]byte("blah"))
+	assert.PanicsWithValue(ErrReadOnly, func() { snapshot.Remove("blah") })
+	assert.PanicsWithValue(ErrReadOnly, func() { snapshot.Clear() })
 
 	// Ensure snapshots-of-snapshots work as expected.
 	snapshot2 := snapshot.Snapshot(ReadWrite)
 	for i := 0; i < 100; i++ {
-		val, ok := snapshot2.Lookup([]byte(strconv.Itoa(i)))
+		val, ok := snapshot2.Lookup(strconv.Itoa(i))
 		assert.True(ok)
 		assert.Equal(i, val)
 	}
-	snapshot2.Remove([]byte("0"))
-	_, ok = snapshot2.Lookup([]byte("0"))
+	snapshot2.Remove("0")
+	_, ok = snapshot2.Lookup("0")
 	assert.False(ok)
-	val, ok = snapshot.Lookup([]byte("0"))
+	val, ok = snapshot.Lookup("0")
 	assert.True(ok)
 	assert.Equal(0, val)
 }
 
+// TestSnapshotRoot checks that a snapshot is built from the root the RDCSS
+// replaced, not the one it installed, which writers to the Ctrie go on to
+// change in place.
+func TestSnapshotRoot(t *testing.T) {
+	assert := assert.New(t)
+	for _, mode := range []IoMode{ReadOnly, ReadWrite} {
+		ctrie := newCtrie[interface{}](nil)
+		for i := 0; i < 100; i++ {
+			ctrie.Insert(strconv.Itoa(i), i)
+		}
+		snapshot := ctrie.Snapshot(mode)
+		for i := 0; i < 100; i++ {
+			ctrie.Insert(strconv.Itoa(i), -i)
+		}
+		ctrie.Insert("foo", "bar")
+		ctrie.Remove("0")
+
+		for i := 0; i < 100; i++ {
+			val, ok := snapshot.Lookup(strconv.Itoa(i))
+			assert.True(ok)
+			assert.Equal(i, val)
+		}
+		_, ok := snapshot.Lookup("foo")
+		assert.False(ok)
+		assert.Equal(uint(100), snapshot.Size())
+	}
+}
+
 func TestIterator(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < 10; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 	expected := map[string]int{
 		"0": 0,
@@ -299,7 +498,7 @@ func TestIterator(t *testing.T) {
 
 	count := 0
 	for entry := range ctrie.Iterate(nil) {
-		exp, ok := expected[string(entry.Key)]
+		exp, ok := expected[entry.Key]
 		if assert.True(ok) {
 			assert.Equal(exp, entry.Value)
 		}
@@ -311,7 +510,7 @@ func TestIterator(t *testing.T) {
 	cancel := make(chan struct{})
 	iter := ctrie.Iterate(cancel)
 	entry := <-iter
-	exp, ok := expected[string(entry.Key)]
+	exp, ok := expected[entry.Key]
 	if assert.True(ok) {
 		assert.Equal(exp, entry.Value)
 	}
@@ -325,19 +524,155 @@ func TestIterator(t *testing.T) {
 	assert.False(ok)
 }
 
+func TestCursor(t *testing.T) {
+	assert := assert.New(t)
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		for i := 0; i < 100; i++ {
+			ctrie.Insert(strconv.Itoa(i), i)
+		}
+		cur := newCursor(ctrie)
+		// Writes after the cursor is created are not seen.
+		ctrie.Insert("foo", "bar")
+		ctrie.Remove("0")
+
+		seen := make(map[string]bool)
+		for cur.next() {
+			assert.False(seen[cur.current.Key])
+			seen[cur.current.Key] = true
+			assert.Equal(cur.current.Key, strconv.Itoa(cur.current.Value.(int)))
+		}
+		assert.Equal(100, len(seen))
+		assert.False(cur.next())
+	}
+
+	ctrie := newCtrie[interface{}](nil)
+	assert.False(newCursor(ctrie).next())
+}
+
+func TestIterateFrom(t *testing.T) {
+	assert := assert.New(t)
+	// Eight distinct hashes, each shared by many keys, give a trie of
+	// L-nodes next to one another.
+	collide := stringKeyer{func(k string) uint64 {
+		n, _ := strconv.Atoi(k)
+		return uint64(n%8) << 58
+	}}
+	for _, keys := range []keyer[string]{stringKeys(nil), stringKeys(mockHashFactory), collide} {
+		ctrie := newKeyedCtrie[string, interface{}](keys)
+		for i := 0; i < 100; i++ {
+			ctrie.Insert(strconv.Itoa(i), i)
+		}
+		snapshot := ctrie.Snapshot(ReadOnly)
+		ctrie.Insert("foo", "bar")
+
+		for _, limit := range []int{1, 7, 100, 0} {
+			var (
+				token Token
+				order []string
+			)
+			for {
+				page, next, err := iterateFrom(snapshot, token, limit)
+				assert.NoError(err)
+				n := 0
+				for page.next() {
+					order = append(order, page.current.Key)
+					n++
+				}
+				if limit > 0 {
+					assert.True(n <= limit)
+				}
+				if next == "" {
+					break
+				}
+				assert.Equal(limit, n)
+				token = next
+			}
+
+			var want []string
+			for cur := newCursor(snapshot); cur.next(); {
+				want = append(want, cur.current.Key)
+			}
+			assert.Equal(want, order)
+		}
+	}
+
+	_, _, err := iterateFrom(newCtrie[interface{}](nil), "not a token", 1)
+	assert.Equal(ErrInvalidToken, err)
+}
+
 func TestSize(t *testing.T) {
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < 10; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 	assert.Equal(t, uint(10), ctrie.Size())
 }
 
+func TestCachedSize(t *testing.T) {
+	assert := assert.New(t)
+	ctrie := newCtrie[interface{}](nil)
+	for i := 0; i < 1000; i++ {
+		ctrie.Insert(strconv.Itoa(i), i)
+	}
+	snapshot := ctrie.Snapshot(ReadOnly)
+	assert.Equal(uint(1000), snapshot.Size())
+	// The size is cached on the snapshot's root main node.
+	main := gcasRead(snapshot.readRoot(), snapshot)
+	assert.Equal(uint64(1001), main.size)
+	assert.Equal(uint(1000), snapshot.Size())
+
+	for i := 0; i < 500; i++ {
+		ctrie.Remove(strconv.Itoa(i))
+	}
+	ctrie.Insert("foo", "bar")
+	assert.Equal(uint(501), ctrie.Size())
+	assert.Equal(uint(501), ctrie.Size())
+	assert.Equal(uint(1000), snapshot.Size())
+
+	count := uint(0)
+	for _ = range ctrie.Iterate(nil) {
+		count++
+	}
+	assert.Equal(uint(501), count)
+}
+
+func TestLen(t *testing.T) {
+	assert := assert.New(t)
+	for _, hf := range []hasher{nil, mockHashFactory} {
+		ctrie := newCtrie[interface{}](hf)
+		for i := 0; i < 100; i++ {
+			ctrie.Insert(strconv.Itoa(i), i)
+			ctrie.Insert(strconv.Itoa(i), i)
+		}
+		assert.Equal(uint(100), ctrie.Len())
+		ctrie.InsertIfAbsent("0", 0)
+		ctrie.InsertIfAbsent("100", 100)
+		ctrie.RemoveIf("1", func(interface{}, bool) bool { return false })
+		ctrie.RemoveIf("2", nil)
+		ctrie.Remove("3")
+		ctrie.Remove("3")
+		ctrie.Update("101", func(interface{}, bool) (interface{}, bool) { return 101, true })
+		ctrie.Update("4", func(interface{}, bool) (interface{}, bool) { return nil, false })
+		assert.Equal(uint(99), ctrie.Len())
+		assert.Equal(ctrie.Size(), ctrie.Len())
+
+		snapshot := ctrie.Snapshot(ReadWrite)
+		snapshot.Remove("5")
+		assert.Equal(uint(98), snapshot.Len())
+		assert.Equal(uint(99), ctrie.Len())
+		assert.Equal(uint(99), ctrie.Snapshot(ReadOnly).Len())
+
+		ctrie.Clear()
+		assert.Equal(uint(0), ctrie.Len())
+	}
+}
+
 func TestClear(t *testing.T) {
 	assert := assert.New(t)
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < 10; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 	assert.Equal(uint(10), ctrie.Size())
 	snapshot := ctrie.Snapshot(ReadWrite)
@@ -349,20 +684,20 @@ func TestClear(t *testing.T) {
 }
 
 func BenchmarkInsert(b *testing.B) {
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ctrie.Insert([]byte("foo"), 0)
+		ctrie.Insert("foo", 0)
 	}
 }
 
 func BenchmarkLookup(b *testing.B) {
 	numItems := 1000
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < numItems; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
-	key := []byte(strconv.Itoa(numItems / 2))
+	key := strconv.Itoa(numItems / 2)
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -372,11 +707,11 @@ func BenchmarkLookup(b *testing.B) {
 
 func BenchmarkRemove(b *testing.B) {
 	numItems := 1000
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < numItems; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
-	key := []byte(strconv.Itoa(numItems / 2))
+	key := strconv.Itoa(numItems / 2)
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -386,9 +721,9 @@ func BenchmarkRemove(b *testing.B) {
 
 func BenchmarkSnapshot(b *testing.B) {
 	numItems := 1000
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < numItems; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 	b.ResetTimer()
 
@@ -399,9 +734,9 @@ func BenchmarkSnapshot(b *testing.B) {
 
 func BenchmarkReadOnlySnapshot(b *testing.B) {
 	numItems := 1000
-	ctrie := newCtrie(nil)
+	ctrie := newCtrie[interface{}](nil)
 	for i := 0; i < numItems; i++ {
-		ctrie.Insert([]byte(strconv.Itoa(i)), i)
+		ctrie.Insert(strconv.Itoa(i), i)
 	}
 	b.ResetTimer()
 
@@ -1,11 +1,28 @@
 package sled
 
-type ErrCanceled struct{}
+import "errors"
 
-func (ErrCanceled) Error() string {
+// ErrReadOnly is returned by writes to a sled which cannot be written to.
+var ErrReadOnly = errors.New("sled is read-only")
+
+// ErrCanceled is returned when an iteration is stopped by its cancel channel
+// or context. Err holds the context's error, if any.
+type ErrCanceled struct {
+	Err error
+}
+
+func (e ErrCanceled) Error() string {
+	if e.Err != nil {
+		return "canceled: " + e.Err.Error()
+	}
 	return "canceled"
 }
 
+// Unwrap returns the context error which caused the cancellation.
+func (e ErrCanceled) Unwrap() error {
+	return e.Err
+}
+
 type ErrGetType struct {
 	a string
 	b string
@@ -0,0 +1,488 @@
+package sled
+
+import (
+	"container/heap"
+	dlist "container/list" // the package has a persistent list of its own
+	"hash/maphash"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// EvictionPolicy chooses the keys a sled with a capacity evicts once it is
+// full. The sled tells the policy about every key written and deleted, and
+// about the keys read, in batches from which reads may be dropped under
+// contention. It asks the policy for victims while it is over capacity. A
+// policy is called from many goroutines at once, and must be safe for
+// concurrent use.
+type EvictionPolicy interface {
+	// Add records that key was written.
+	Add(key string)
+
+	// Access records that key was read.
+	Access(key string)
+
+	// Remove forgets key, which was deleted.
+	Remove(key string)
+
+	// Victim chooses a key to evict and forgets it. It returns false if
+	// the policy holds no keys.
+	Victim() (key string, ok bool)
+}
+
+// limits bound the size of a sled.
+type limits struct {
+	maxEntries uint
+	maxBytes   int64
+	policy     EvictionPolicy
+	reads      accessBuffer
+	onEvict    func(key string, value interface{})
+}
+
+const (
+	accessStripes = 16
+	accessBatch   = 64
+)
+
+// accessBuffer batches the reads of a bounded sled, so that readers do not
+// serialize on the lock of its policy. A read is recorded in a stripe picked
+// at random, and the reader which fills a stripe hands the batch to the
+// policy. Like the read buffers of W-TinyLFU implementations it is lossy: a
+// read is dropped rather than waited for if its stripe is busy, as is a
+// batch while another is being handed over. Reads only inform the choice of
+// victims, which a few lost ones barely change.
+type accessBuffer struct {
+	stripes [accessStripes]struct {
+		mu   sync.Mutex
+		keys []string
+	}
+	drain sync.Mutex
+}
+
+// record buffers a read of key.
+func (b *accessBuffer) record(p EvictionPolicy, key string) {
+	st := &b.stripes[rand.Uint32()%accessStripes]
+	if !st.mu.TryLock() {
+		return
+	}
+	st.keys = append(st.keys, key)
+	if len(st.keys) < accessBatch {
+		st.mu.Unlock()
+		return
+	}
+	keys := st.keys
+	st.keys = make([]string, 0, accessBatch)
+	st.mu.Unlock()
+	if b.drain.TryLock() {
+		for _, k := range keys {
+			p.Access(k)
+		}
+		b.drain.Unlock()
+	}
+}
+
+// flush hands every buffered read to the policy, so that it chooses victims
+// knowing of them.
+func (b *accessBuffer) flush(p EvictionPolicy) {
+	b.drain.Lock()
+	defer b.drain.Unlock()
+	for i := range b.stripes {
+		st := &b.stripes[i]
+		st.mu.Lock()
+		keys := st.keys
+		st.keys = nil
+		st.mu.Unlock()
+		for _, k := range keys {
+			p.Access(k)
+		}
+	}
+}
+
+// over reports whether the sled holds more than its limits allow.
+func (s *sled) over() bool {
+	return s.limits.maxEntries > 0 && s.m.Len() > s.limits.maxEntries ||
+		s.limits.maxBytes > 0 && atomic.LoadInt64(&s.bytes) > s.limits.maxBytes
+}
+
+// evict removes the victims chosen by the policy while the sled is over its
+// limits. Evictions are ordinary removes from the trie, so snapshots keep
+// the keys they saw and readers never see a half removed key.
+func (s *sled) evict() {
+	if s.over() {
+		s.limits.reads.flush(s.limits.policy)
+	}
+	for s.over() {
+		key, ok := s.limits.policy.Victim()
+		if !ok {
+			return
+		}
+		old, existed := s.m.ct.Remove(key)
+		if !existed {
+			continue
+		}
+		s.wrote(key, old, true, nil, false, nil)
+		if s.limits.onEvict == nil {
+			continue
+		}
+		v, _ := liveAt(old, 0)
+		if s.wal != nil {
+			// The write holds the lock of the log, so the function is
+			// called once it unlocks.
+			s.wal.evicted = append(s.wal.evicted, eviction{key, v})
+		} else {
+			s.limits.onEvict(key, v)
+		}
+	}
+}
+
+// eviction is a key evicted with its value.
+type eviction struct {
+	key   string
+	value interface{}
+}
+
+// lru evicts the least recently used key.
+type lru struct {
+	mu    sync.Mutex
+	order *dlist.List
+	keys  map[string]*dlist.Element
+}
+
+// NewLRU returns an EvictionPolicy which evicts the least recently written
+// or read key.
+func NewLRU() EvictionPolicy {
+	return &lru{order: dlist.New(), keys: make(map[string]*dlist.Element)}
+}
+
+func (p *lru) Add(key string) {
+	p.mu.Lock()
+	if el, ok := p.keys[key]; ok {
+		p.order.MoveToFront(el)
+	} else {
+		p.keys[key] = p.order.PushFront(key)
+	}
+	p.mu.Unlock()
+}
+
+func (p *lru) Access(key string) {
+	p.mu.Lock()
+	if el, ok := p.keys[key]; ok {
+		p.order.MoveToFront(el)
+	}
+	p.mu.Unlock()
+}
+
+func (p *lru) Remove(key string) {
+	p.mu.Lock()
+	if el, ok := p.keys[key]; ok {
+		p.order.Remove(el)
+		delete(p.keys, key)
+	}
+	p.mu.Unlock()
+}
+
+func (p *lru) Victim() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	el := p.order.Back()
+	if el == nil {
+		return "", false
+	}
+	key := p.order.Remove(el).(string)
+	delete(p.keys, key)
+	return key, true
+}
+
+// lfu evicts the least frequently used key, and of those the least recently
+// used. Keys are kept in a min-heap ordered by use count, then by the tick of
+// their last use.
+type lfu struct {
+	mu   sync.Mutex
+	tick uint64
+	heap lfuHeap
+	keys map[string]*lfuEntry
+}
+
+type lfuEntry struct {
+	key   string
+	count uint64
+	tick  uint64
+	index int
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewLFU returns an EvictionPolicy which evicts the least frequently written
+// or read key, breaking ties by evicting the least recently used.
+func NewLFU() EvictionPolicy {
+	return &lfu{keys: make(map[string]*lfuEntry)}
+}
+
+func (p *lfu) Add(key string) {
+	p.mu.Lock()
+	if !p.use(key) {
+		p.tick++
+		e := &lfuEntry{key: key, count: 1, tick: p.tick}
+		heap.Push(&p.heap, e)
+		p.keys[key] = e
+	}
+	p.mu.Unlock()
+}
+
+func (p *lfu) Access(key string) {
+	p.mu.Lock()
+	p.use(key)
+	p.mu.Unlock()
+}
+
+// use counts a use of key, reporting false if the key is unknown.
+func (p *lfu) use(key string) bool {
+	e, ok := p.keys[key]
+	if !ok {
+		return false
+	}
+	p.tick++
+	e.count++
+	e.tick = p.tick
+	heap.Fix(&p.heap, e.index)
+	return true
+}
+
+func (p *lfu) Remove(key string) {
+	p.mu.Lock()
+	if e, ok := p.keys[key]; ok {
+		heap.Remove(&p.heap, e.index)
+		delete(p.keys, key)
+	}
+	p.mu.Unlock()
+}
+
+func (p *lfu) Victim() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.heap) == 0 {
+		return "", false
+	}
+	e := heap.Pop(&p.heap).(*lfuEntry)
+	delete(p.keys, e.key)
+	return e.key, true
+}
+
+// tinyLFU is W-TinyLFU: new keys enter an LRU window of 1% of the keys, and
+// a key pushed out of the window while the sled is full is only admitted to
+// the main space if it has been used more often than the key it would
+// displace. The main space is a segmented LRU
+// of a probation segment and a protected segment, which keys read while on
+// probation are promoted to. Use counts are estimated by a count-min sketch
+// which is halved periodically, so that past popularity fades.
+type tinyLFU struct {
+	mu        sync.Mutex
+	sketch    *cmSketch
+	window    *dlist.List
+	probation *dlist.List
+	protected *dlist.List
+	keys      map[string]*dlist.Element
+
+	// candidate is the key last pushed out of the window, which the next
+	// Victim decides whether to admit.
+	candidate *dlist.Element
+}
+
+// tinyLFUEntry is a key and the segment it is in.
+type tinyLFUEntry struct {
+	key     string
+	segment *dlist.List
+}
+
+// NewTinyLFU returns a W-TinyLFU EvictionPolicy, which resists being flushed
+// by scans and one-off keys better than LRU while adapting to changes in
+// popularity faster than LFU. capacity is the expected number of keys, which
+// sizes the frequency sketch.
+func NewTinyLFU(capacity int) EvictionPolicy {
+	return &tinyLFU{
+		sketch:    newCMSketch(capacity),
+		window:    dlist.New(),
+		probation: dlist.New(),
+		protected: dlist.New(),
+		keys:      make(map[string]*dlist.Element),
+	}
+}
+
+func (p *tinyLFU) Add(key string) {
+	p.mu.Lock()
+	p.sketch.increment(key)
+	if el, ok := p.keys[key]; ok {
+		p.access(el)
+	} else {
+		p.keys[key] = p.window.PushFront(&tinyLFUEntry{key, p.window})
+		if p.window.Len() > len(p.keys)/100+1 {
+			p.move(p.window.Back(), p.probation)
+			p.candidate = p.probation.Front()
+		}
+	}
+	p.mu.Unlock()
+}
+
+func (p *tinyLFU) Access(key string) {
+	p.mu.Lock()
+	p.sketch.increment(key)
+	if el, ok := p.keys[key]; ok {
+		p.access(el)
+	}
+	p.mu.Unlock()
+}
+
+// access moves a key to the front of its segment, promoting it from
+// probation to the protected segment. The protected segment is kept to 80%
+// of the main space by demoting its least recently used keys.
+func (p *tinyLFU) access(el *dlist.Element) {
+	e := el.Value.(*tinyLFUEntry)
+	if e.segment != p.probation {
+		e.segment.MoveToFront(el)
+		return
+	}
+	p.move(el, p.protected)
+	main := p.probation.Len() + p.protected.Len()
+	for p.protected.Len() > main*4/5 {
+		p.move(p.protected.Back(), p.probation)
+	}
+}
+
+// move moves a key to the front of another segment.
+func (p *tinyLFU) move(el *dlist.Element, to *dlist.List) {
+	if el == p.candidate {
+		p.candidate = nil
+	}
+	e := el.Value.(*tinyLFUEntry)
+	e.segment.Remove(el)
+	e.segment = to
+	p.keys[e.key] = to.PushFront(e)
+}
+
+func (p *tinyLFU) Remove(key string) {
+	p.mu.Lock()
+	if el, ok := p.keys[key]; ok {
+		p.remove(el)
+	}
+	p.mu.Unlock()
+}
+
+func (p *tinyLFU) remove(el *dlist.Element) string {
+	if el == p.candidate {
+		p.candidate = nil
+	}
+	e := el.Value.(*tinyLFUEntry)
+	e.segment.Remove(el)
+	delete(p.keys, e.key)
+	return e.key
+}
+
+// Victim decides on the admission of the candidate pushed out of the window,
+// evicting either it or the least recently used key on probation, whichever
+// the sketch estimates to be used less often. Without a candidate it evicts
+// from probation, then from the protected segment, then from the window.
+func (p *tinyLFU) Victim() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c := p.candidate; c != nil {
+		p.candidate = nil
+		if v := p.probation.Back(); v != c && p.estimate(c) > p.estimate(v) {
+			return p.remove(v), true
+		}
+		return p.remove(c), true
+	}
+	for _, segment := range []*dlist.List{p.probation, p.protected, p.window} {
+		if el := segment.Back(); el != nil {
+			return p.remove(el), true
+		}
+	}
+	return "", false
+}
+
+func (p *tinyLFU) estimate(el *dlist.Element) uint8 {
+	return p.sketch.estimate(el.Value.(*tinyLFUEntry).key)
+}
+
+// cmSketch is a count-min sketch of saturating 4 bit counters, four rows
+// deep and four counters wide per expected key. Once it has counted ten
+// samples per expected key, every counter is halved.
+type cmSketch struct {
+	seed    maphash.Seed
+	rows    [4][]uint8
+	mask    uint64
+	samples int
+	reset   int
+}
+
+func newCMSketch(capacity int) *cmSketch {
+	if capacity < 16 {
+		capacity = 16
+	}
+	width := 1
+	for width < 4*capacity {
+		width <<= 1
+	}
+	s := &cmSketch{seed: maphash.MakeSeed(), mask: uint64(width - 1), reset: 10 * capacity}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter of key in row i, using double hashing to derive
+// the four indexes from one 64 bit hash.
+func (s *cmSketch) index(h uint64, i int) uint64 {
+	return (h + uint64(i)*(h>>32|1)) & s.mask
+}
+
+func (s *cmSketch) increment(key string) {
+	h := maphash.String(s.seed, key)
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < 15 {
+			*c++
+		}
+	}
+	if s.samples++; s.samples >= s.reset {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.samples /= 2
+	}
+}
+
+func (s *cmSketch) estimate(key string) uint8 {
+	h := maphash.String(s.seed, key)
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
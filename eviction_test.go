@@ -0,0 +1,148 @@
+package sled
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func victims(p EvictionPolicy) []string {
+	var keys []string
+	for {
+		key, ok := p.Victim()
+		if !ok {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	assert := assert.New(t)
+	p := NewLRU()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		p.Add(k)
+	}
+	p.Access("a")
+	p.Add("b")
+	p.Remove("c")
+	p.Access("missing")
+	assert.Equal([]string{"d", "a", "b"}, victims(p))
+}
+
+func TestLFU(t *testing.T) {
+	assert := assert.New(t)
+	p := NewLFU()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		p.Add(k)
+	}
+	p.Access("a")
+	p.Access("a")
+	p.Access("b")
+	p.Access("d")
+	p.Remove("c")
+	// b and d are used as often, and b less recently.
+	assert.Equal([]string{"b", "d", "a"}, victims(p))
+}
+
+func TestTinyLFU(t *testing.T) {
+	assert := assert.New(t)
+	p := NewTinyLFU(100)
+	for i := 0; i < 100; i++ {
+		p.Add("hot" + strconv.Itoa(i))
+	}
+	for r := 0; r < 5; r++ {
+		for i := 0; i < 100; i++ {
+			p.Access("hot" + strconv.Itoa(i))
+		}
+	}
+	// A scan of one-off keys is evicted ahead of the keys still in use.
+	// Only the few popular keys in the window when it starts may lose
+	// ties to it, where LRU would evict every popular key.
+	hot := 0
+	for i := 0; i < 1000; i++ {
+		p.Access("hot" + strconv.Itoa(i%100))
+		p.Add("scan" + strconv.Itoa(i))
+		key, ok := p.Victim()
+		assert.True(ok)
+		if strings.HasPrefix(key, "hot") {
+			hot++
+		}
+	}
+	assert.True(hot <= 5)
+
+	p.Remove("hot0")
+	keys := victims(p)
+	assert.NotContains(keys, "hot0")
+	hot = 0
+	for _, key := range keys {
+		if strings.HasPrefix(key, "hot") {
+			hot++
+		}
+	}
+	assert.True(hot >= 94)
+}
+
+func TestCMSketch(t *testing.T) {
+	assert := assert.New(t)
+	s := newCMSketch(16)
+	for i := 0; i < 20; i++ {
+		s.increment("a")
+	}
+	s.increment("b")
+	assert.Equal(uint8(15), s.estimate("a"))
+	assert.True(s.estimate("b") >= 1)
+
+	// Counters are halved after ten samples per expected key.
+	for i := 0; i < 10*16; i++ {
+		s.increment("c")
+	}
+	assert.True(s.estimate("a") < 15)
+}
+
+// countingPolicy counts the reads it is told of.
+type countingPolicy struct {
+	EvictionPolicy
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (p *countingPolicy) Access(key string) {
+	p.mu.Lock()
+	p.reads[key]++
+	p.mu.Unlock()
+}
+
+func TestAccessBuffer(t *testing.T) {
+	assert := assert.New(t)
+	p := &countingPolicy{EvictionPolicy: NewLRU(), reads: make(map[string]int)}
+	var b accessBuffer
+
+	// Reads reach the policy in batches, or when it is flushed.
+	b.record(p, "a")
+	assert.Empty(p.reads)
+	b.flush(p)
+	assert.Equal(map[string]int{"a": 1}, p.reads)
+	for i := 0; i < accessStripes*accessBatch; i++ {
+		b.record(p, "b")
+	}
+	assert.NotZero(p.reads["b"])
+
+	// Concurrent readers never wait for each other, and may drop reads.
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				b.record(p, "c")
+			}
+		}()
+	}
+	wg.Wait()
+	b.flush(p)
+	assert.True(p.reads["c"] > 0 && p.reads["c"] <= 8000)
+}
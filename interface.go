@@ -1,13 +1,46 @@
 package sled
 
+import (
+	"context"
+	"io"
+	"iter"
+	"time"
+)
+
 // Sled is an interface for sled key value store types.
 type Sled interface {
 	Set(key string, v interface{}) error
+	SetWithTTL(key string, v interface{}, ttl time.Duration) error
+	Expire(key string, ttl time.Duration) bool
 	Get(key string, v interface{}) error
 	SetIfNil(string, interface{}) bool
+	TrySetIfNil(key string, v interface{}) (bool, error)
+	GetOrSet(key string, v interface{}) (actual interface{}, loaded bool)
+	CompareAndSwap(key string, old, new interface{}) bool
+	CompareAndDelete(key string, old interface{}) bool
+	Update(key string, fn UpdateFunc) error
 	Delete(string) (interface{}, bool)
+	TryDelete(key string) (interface{}, bool, error)
 	Close() error
 	Iterate(<-chan struct{}) <-chan Element
+	Iterator() Iterator
+	IterateContext(ctx context.Context) Iterator
+	IterateFrom(token Token, limit int) (Iterator, Token, error)
+	Range(ctx context.Context, fn func(key string, v interface{}) bool) error
+	ParallelRange(ctx context.Context, workers int, fn func(key string, v interface{}) bool) error
+	All() iter.Seq2[string, interface{}]
+	Keys() iter.Seq[string]
+	Ascend(from, to string) iter.Seq2[string, interface{}]
+	Descend(from, to string) iter.Seq2[string, interface{}]
+	Min() (key string, value interface{}, ok bool)
+	Max() (key string, value interface{}, ok bool)
+	ScanPrefix(prefix string) iter.Seq2[string, interface{}]
+	ScanGlob(pattern string) (iter.Seq2[string, interface{}], error)
 	Snapshot(IoMode) Sled
+	WriteTo(w io.Writer) (int64, error)
+	Checkpoint() error
 	Size() uint
+	Len() uint
+	Bytes() int64
+	BytesByPrefix(prefixes ...string) map[string]int64
 }
@@ -0,0 +1,148 @@
+package sled
+
+import "context"
+
+// cursor walks the entries of a read-only Ctrie depth first. The path from
+// the root is kept on an explicit stack rather than the call stack, so the
+// walk can stop between any two entries without a goroutine or channel, and
+// a cursor which is abandoned simply becomes garbage.
+type cursor[K, V any] struct {
+	c *ctrie[K, V]
+
+	// stack holds the branches of each C-node on the path from the root
+	// which are yet to be visited.
+	stack [][]branch
+
+	// pending holds the entries of an L-node or T-node yet to be yielded.
+	pending []*entry[K, V]
+
+	current *entry[K, V]
+}
+
+// newCursor returns a cursor positioned before the first entry of a read-only
+// snapshot of the Ctrie.
+func newCursor[K, V any](c *ctrie[K, V]) *cursor[K, V] {
+	snapshot := c.Snapshot(ReadOnly)
+	cur := &cursor[K, V]{c: snapshot}
+	cur.push(snapshot.readRoot())
+	return cur
+}
+
+// branchCursor returns a cursor over the entries below a single branch of a
+// read-only Ctrie.
+func branchCursor[K, V any](c *ctrie[K, V], br branch) *cursor[K, V] {
+	cur := &cursor[K, V]{c: c}
+	switch b := br.(type) {
+	case *iNode[K, V]:
+		cur.push(b)
+	case *sNode[K, V]:
+		cur.pending = append(cur.pending, b.entry)
+	}
+	return cur
+}
+
+// push descends into the I-node.
+func (cur *cursor[K, V]) push(i *iNode[K, V]) {
+	main := gcasRead(i, cur.c)
+	switch {
+	case main.cNode != nil:
+		cur.stack = append(cur.stack, main.cNode.array)
+	case main.tNode != nil:
+		// An entombed S-node is still part of the trie until it is
+		// compressed into its parent.
+		cur.pending = append(cur.pending, main.tNode.entry)
+	case main.lNode != nil:
+		for _, sn := range main.lNode.Map(func(sn interface{}) interface{} {
+			return sn
+		}) {
+			cur.pending = append(cur.pending, sn.(*sNode[K, V]).entry)
+		}
+	}
+}
+
+// next advances to the next entry and reports whether there is one.
+func (cur *cursor[K, V]) next() bool {
+	for {
+		if len(cur.pending) > 0 {
+			cur.current = cur.pending[0]
+			cur.pending = cur.pending[1:]
+			return true
+		}
+		top := len(cur.stack) - 1
+		if top < 0 {
+			cur.current = nil
+			return false
+		}
+		branches := cur.stack[top]
+		if len(branches) == 0 {
+			cur.stack = cur.stack[:top]
+			continue
+		}
+		cur.stack[top] = branches[1:]
+		switch b := branches[0].(type) {
+		case *iNode[K, V]:
+			cur.push(b)
+		case *sNode[K, V]:
+			cur.current = b.entry
+			return true
+		}
+	}
+}
+
+// Iter is a cursor over the keys and values of a point in time image of a
+// HashMap. It runs entirely in the caller's goroutine, so an Iter can be
+// abandoned at any point without leaking anything.
+//
+//	it := m.Iterator()
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+type Iter[K, V any] struct {
+	cur *cursor[K, V]
+	ctx context.Context
+	err error
+}
+
+// Next advances the Iter to the next key and value, which are then available
+// through Key and Value. It returns false when the iteration is done, or when
+// the Iter's context is done.
+func (it *Iter[K, V]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.ctx != nil {
+		select {
+		case <-it.ctx.Done():
+			it.err = ErrCanceled{it.ctx.Err()}
+			return false
+		default:
+		}
+	}
+	return it.cur.next()
+}
+
+// Err returns an ErrCanceled wrapping the context's error if the iteration
+// was stopped by its context, and nil otherwise.
+func (it *Iter[K, V]) Err() error {
+	return it.err
+}
+
+// Key returns the key of the current entry, or the zero K if there is none:
+// before the first call to Next, or once Next has returned false.
+func (it *Iter[K, V]) Key() K {
+	if it.cur.current == nil {
+		var zero K
+		return zero
+	}
+	return it.cur.current.Key
+}
+
+// Value returns the value of the current entry, or the zero V if there is
+// none, as Key does.
+func (it *Iter[K, V]) Value() V {
+	if it.cur.current == nil {
+		var zero V
+		return zero
+	}
+	return it.cur.current.Value
+}
@@ -0,0 +1,91 @@
+package sled
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/maphash"
+)
+
+// Hashable is implemented by key types which provide their own hashing and
+// equality. Keys that are equal must have equal hashes.
+type Hashable[K any] interface {
+	Hash() uint64
+	Equal(other K) bool
+}
+
+// hasher returns a new Hash64 used to hash keys.
+type hasher func() hash.Hash64
+
+// keyer hashes and compares the keys of a Ctrie.
+type keyer[K any] interface {
+	hash(k K) uint64
+	equal(a, b K) bool
+}
+
+// stringKeyer hashes string keys with a hash function.
+type stringKeyer struct {
+	hashFn func(string) uint64
+}
+
+// stringKeys returns a keyer for string keys which uses the provided hasher.
+// If nil is passed in, it will default to keyed SipHash-2-4 hashing.
+func stringKeys(hashFactory hasher) keyer[string] {
+	return seededStringKeys(hashFactory, nil)
+}
+
+// seededStringKeys is stringKeys with a seed mixed into every hash. The
+// default SipHash-2-4 hashes strings in place without allocating, while a
+// Hash64 from a factory costs an allocation per hash.
+func seededStringKeys(hashFactory hasher, seed []byte) keyer[string] {
+	if hashFactory == nil {
+		var s [8]byte
+		copy(s[:], seed)
+		k0 := sipKey[0] ^ binary.LittleEndian.Uint64(s[:])
+		return stringKeyer{func(k string) uint64 {
+			return sipHashString(k0, sipKey[1], k)
+		}}
+	}
+	return stringKeyer{func(k string) uint64 {
+		h := hashFactory()
+		h.Write(seed)
+		h.Write([]byte(k))
+		return h.Sum64()
+	}}
+}
+
+func (s stringKeyer) hash(k string) uint64 {
+	return s.hashFn(k)
+}
+
+func (stringKeyer) equal(a, b string) bool {
+	return a == b
+}
+
+// comparableKeyer hashes any comparable key in place with a per-instance
+// random seed, and compares keys with ==.
+type comparableKeyer[K comparable] struct {
+	seed maphash.Seed
+}
+
+func comparableKeys[K comparable]() keyer[K] {
+	return comparableKeyer[K]{maphash.MakeSeed()}
+}
+
+func (c comparableKeyer[K]) hash(k K) uint64 {
+	return maphash.Comparable(c.seed, k)
+}
+
+func (comparableKeyer[K]) equal(a, b K) bool {
+	return a == b
+}
+
+// hashableKeyer defers hashing and equality to the keys themselves.
+type hashableKeyer[K Hashable[K]] struct{}
+
+func (hashableKeyer[K]) hash(k K) uint64 {
+	return k.Hash()
+}
+
+func (hashableKeyer[K]) equal(a, b K) bool {
+	return a.Equal(b)
+}
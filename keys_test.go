@@ -0,0 +1,32 @@
+package sled
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparableKeys(t *testing.T) {
+	assert := assert.New(t)
+	k := comparableKeys[interface{}]()
+
+	type point struct {
+		x, y float64
+		_    int
+		name string
+	}
+	negZero := math.Copysign(0, -1)
+	assert.Equal(k.hash(0.0), k.hash(negZero))
+	assert.Equal(k.hash(point{x: 1, name: "a"}), k.hash(point{x: 1, y: negZero, name: "a"}))
+	assert.NotEqual(k.hash(point{name: "ab"}), k.hash(point{name: "a"}))
+
+	p, q := new(int), new(int)
+	assert.Equal(k.hash(p), k.hash(p))
+	assert.NotEqual(k.hash(p), k.hash(q))
+
+	assert.Equal(k.hash([2]string{"a", "b"}), k.hash([2]string{"a", "b"}))
+	assert.NotEqual(k.hash([2]string{"a", "b"}), k.hash(nil))
+	assert.NotEqual(k.hash(point{}), k.hash([2]string{}))
+	assert.True(k.equal(point{x: 1}, point{x: 1, y: negZero}))
+}
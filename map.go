@@ -0,0 +1,176 @@
+package sled
+
+import (
+	"context"
+	"iter"
+)
+
+// HashMap is a type safe key value store backed by the same non-blocking
+// ctrie as Sled. Keys and values are kept in the trie as K and V, so neither
+// needs an interface conversion, reflection or marshaling, and type errors
+// are caught at compile time.
+//
+// Unlike the writes of a Sled, which return ErrReadOnly, the writes of a
+// HashMap panic with ErrReadOnly on a read-only snapshot: Store, Swap,
+// LoadOrStore, Update and Delete.
+type HashMap[K, V any] struct {
+	ct *ctrie[K, V]
+}
+
+// NewHashMap creates a new, empty HashMap for any comparable key type. Keys
+// are hashed in place with a random per-map seed and compared with ==.
+func NewHashMap[K comparable, V any]() *HashMap[K, V] {
+	return &HashMap[K, V]{newKeyedCtrie[K, V](comparableKeys[K]())}
+}
+
+// NewHashableMap creates a new, empty HashMap for a key type which provides
+// its own Hash and Equal methods.
+func NewHashableMap[K Hashable[K], V any]() *HashMap[K, V] {
+	return &HashMap[K, V]{newKeyedCtrie[K, V](hashableKeyer[K]{})}
+}
+
+// Load returns the value stored for key, and whether it was found.
+func (m *HashMap[K, V]) Load(key K) (V, bool) {
+	return m.ct.Lookup(key)
+}
+
+// Store assigns value to key, replacing any previous value. It panics with
+// ErrReadOnly on a read-only snapshot.
+func (m *HashMap[K, V]) Store(key K, value V) {
+	m.ct.Insert(key, value)
+}
+
+// Swap assigns value to key and returns the previous value, if any. The
+// loaded result reports whether the key was present. It panics with
+// ErrReadOnly on a read-only snapshot.
+func (m *HashMap[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	return m.ct.Swap(key, value)
+}
+
+// LoadOrStore returns the existing value for key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored. It panics with ErrReadOnly on a read-only
+// snapshot.
+func (m *HashMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	return m.ct.InsertIfAbsent(key, value)
+}
+
+// Update atomically replaces the value of key with the result of fn, or
+// deletes key if fn returns keep == false. fn may be called more than once
+// and should not have side effects. It returns the resulting value and
+// whether key is set. It panics with ErrReadOnly on a read-only snapshot.
+func (m *HashMap[K, V]) Update(key K, fn func(old V, exists bool) (value V, keep bool)) (V, bool) {
+	return m.ct.Update(key, fn)
+}
+
+// Delete removes key, and returns its previous value with an existed flag
+// that will be true if the key was set. It panics with ErrReadOnly on a
+// read-only snapshot.
+func (m *HashMap[K, V]) Delete(key K) (value V, existed bool) {
+	return m.ct.Remove(key)
+}
+
+// Range calls fn for each key and value in a point in time image of the
+// HashMap. If fn returns false, iteration stops.
+func (m *HashMap[K, V]) Range(fn func(key K, value V) bool) {
+	for it := m.Iterator(); it.Next(); {
+		if !fn(it.Key(), it.Value()) {
+			return
+		}
+	}
+}
+
+// RangeContext is Range which stops once ctx is done. It then returns an
+// ErrCanceled wrapping ctx.Err(), which errors.Is matches against
+// context.Canceled or context.DeadlineExceeded.
+func (m *HashMap[K, V]) RangeContext(ctx context.Context, fn func(key K, value V) bool) error {
+	it := m.IteratorContext(ctx)
+	for it.Next() {
+		if !fn(it.Key(), it.Value()) {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+// Iterator returns an Iter over a point in time image of the HashMap.
+func (m *HashMap[K, V]) Iterator() *Iter[K, V] {
+	return &Iter[K, V]{cur: newCursor(m.ct)}
+}
+
+// IteratorContext returns an Iter over a point in time image of the HashMap
+// which stops once ctx is done.
+func (m *HashMap[K, V]) IteratorContext(ctx context.Context) *Iter[K, V] {
+	return &Iter[K, V]{cur: newCursor(m.ct), ctx: ctx}
+}
+
+// All returns an iterator over the keys and values of a point in time image
+// of the HashMap, for use with range. Each range over it takes a new image.
+func (m *HashMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.Range(yield)
+	}
+}
+
+// Keys returns an iterator over the keys of a point in time image of the
+// HashMap, for use with range.
+func (m *HashMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for cur := newCursor(m.ct); cur.next(); {
+			if !yield(cur.current.Key) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values of a point in time image of the
+// HashMap, for use with range.
+func (m *HashMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for cur := newCursor(m.ct); cur.next(); {
+			if !yield(cur.current.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot returns a single point in time image of the HashMap.
+// Snapshot is fast and non blocking. Writes to a read-only snapshot panic
+// with ErrReadOnly.
+func (m *HashMap[K, V]) Snapshot(mode IoMode) *HashMap[K, V] {
+	return &HashMap[K, V]{m.ct.Snapshot(mode)}
+}
+
+// Size returns the number of keys in the HashMap. Repeated calls are
+// amortized O(1), as unchanged parts of the trie keep their counts.
+func (m *HashMap[K, V]) Size() uint {
+	return m.ct.Size()
+}
+
+// Len returns the number of keys in the HashMap from a counter kept by
+// writes. It is cheaper than Size but approximate while writes are in
+// flight.
+func (m *HashMap[K, V]) Len() uint {
+	return m.ct.Len()
+}
+
+// Map is a HashMap with string keys, the type safe counterpart of a Sled. Its
+// writes panic with ErrReadOnly on a read-only snapshot, as a HashMap's do.
+type Map[V any] struct {
+	*HashMap[string, V]
+}
+
+// NewMap creates a new, empty Map configured by the given options.
+func NewMap[V any](opts ...Option) *Map[V] {
+	cfg := newConfig(opts)
+	return &Map[V]{&HashMap[string, V]{newKeyedCtrie[string, V](cfg.keys())}}
+}
+
+// Snapshot returns a single point in time image of the Map.
+// Snapshot is fast and non blocking. Writes to a read-only snapshot panic
+// with ErrReadOnly.
+func (m *Map[V]) Snapshot(mode IoMode) *Map[V] {
+	return &Map[V]{m.HashMap.Snapshot(mode)}
+}
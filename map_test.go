@@ -0,0 +1,208 @@
+package sled_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/cheekybits/is"
+
+	"github.com/Avalanche-io/sled"
+)
+
+func TestMapLoadStore(t *testing.T) {
+	is := is.New(t)
+	type TestStruct struct {
+		Foo string
+	}
+	m := sled.NewMap[TestStruct]()
+
+	_, ok := m.Load("foo")
+	is.False(ok)
+	m.Store("foo", TestStruct{"bar"})
+	v, ok := m.Load("foo")
+	is.True(ok)
+	is.Equal(v, TestStruct{"bar"})
+
+	actual, loaded := m.LoadOrStore("foo", TestStruct{"baz"})
+	is.True(loaded)
+	is.Equal(actual, TestStruct{"bar"})
+
+	v, ok = m.Delete("foo")
+	is.True(ok)
+	is.Equal(v, TestStruct{"bar"})
+	_, ok = m.Load("foo")
+	is.False(ok)
+}
+
+func TestMapSwap(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewMap[string]()
+	m.Store("foo", "bar")
+
+	prev, loaded := m.Swap("foo", "qux")
+	is.True(loaded)
+	is.Equal(prev, "bar")
+	_, loaded = m.Swap("new", "")
+	is.False(loaded)
+	is.Equal(m.Len(), uint(2))
+	v, _ := m.Load("foo")
+	is.Equal(v, "qux")
+}
+
+func TestMapUpdate(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewMap[int]()
+	incr := func(old int, exists bool) (int, bool) {
+		return old + 1, true
+	}
+	for i := 0; i < 3; i++ {
+		m.Update("counter", incr)
+	}
+	v, _ := m.Load("counter")
+	is.Equal(v, 3)
+	_, ok := m.Update("counter", func(old int, exists bool) (int, bool) {
+		return 0, false
+	})
+	is.False(ok)
+	is.Equal(m.Size(), 0)
+}
+
+func TestMapRangeSnapshot(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewMap[int]()
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	snap := m.Snapshot(sled.ReadOnly)
+	m.Store("10", 10)
+
+	seen := make(map[string]int)
+	snap.Range(func(key string, value int) bool {
+		seen[key] = value
+		return true
+	})
+	is.Equal(len(seen), 10)
+	for k, v := range seen {
+		is.Equal(k, strconv.Itoa(v))
+	}
+
+	cnt := 0
+	m.Range(func(key string, value int) bool {
+		cnt++
+		return cnt < 3
+	})
+	is.Equal(cnt, 3)
+	is.Equal(m.Size(), 11)
+}
+
+func TestMapAll(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewMap[int]()
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	sum := 0
+	for k, v := range m.All() {
+		is.Equal(k, strconv.Itoa(v))
+		sum += v
+	}
+	is.Equal(sum, 45)
+	sum = 0
+	for v := range m.Values() {
+		sum += v
+	}
+	is.Equal(sum, 45)
+	cnt := 0
+	for range m.Keys() {
+		cnt++
+	}
+	is.Equal(cnt, 10)
+}
+
+type compositeKey struct {
+	Tenant int
+	ID     [16]byte
+}
+
+func TestHashMapComparableKeys(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewHashMap[compositeKey, string]()
+	for i := 0; i < 100; i++ {
+		m.Store(compositeKey{Tenant: i % 3, ID: [16]byte{byte(i)}}, strconv.Itoa(i))
+	}
+	is.Equal(m.Size(), 100)
+	for i := 0; i < 100; i++ {
+		v, ok := m.Load(compositeKey{Tenant: i % 3, ID: [16]byte{byte(i)}})
+		is.True(ok)
+		is.Equal(v, strconv.Itoa(i))
+	}
+	_, ok := m.Load(compositeKey{Tenant: 1, ID: [16]byte{0}})
+	is.False(ok)
+
+	ints := sled.NewHashMap[int, int]()
+	ints.Store(42, 1)
+	allocs := testing.AllocsPerRun(100, func() {
+		ints.Load(42)
+	})
+	is.Equal(allocs, 0)
+}
+
+// lenKey hashes by length only, so keys of equal length collide.
+type lenKey string
+
+func (k lenKey) Hash() uint64 {
+	return uint64(len(k))
+}
+
+func (k lenKey) Equal(other lenKey) bool {
+	return k == other
+}
+
+func TestHashableMap(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewHashableMap[lenKey, int]()
+	for i := 0; i < 100; i++ {
+		m.Store(lenKey(strconv.Itoa(i)), i)
+	}
+	is.Equal(m.Size(), 100)
+	for i := 0; i < 100; i++ {
+		v, ok := m.Load(lenKey(strconv.Itoa(i)))
+		is.True(ok)
+		is.Equal(v, i)
+	}
+	for i := 0; i < 100; i += 2 {
+		_, ok := m.Delete(lenKey(strconv.Itoa(i)))
+		is.True(ok)
+	}
+	is.Equal(m.Size(), 50)
+	_, ok := m.Load(lenKey("10"))
+	is.False(ok)
+	v, ok := m.Load(lenKey("11"))
+	is.True(ok)
+	is.Equal(v, 11)
+}
+
+func TestParallelMapReduce(t *testing.T) {
+	is := is.New(t)
+	m := sled.NewHashMap[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Store(i, i)
+	}
+	max, err := sled.ParallelMapReduce(context.Background(), m, 0, -1,
+		func(k, v int) int { return v },
+		func(a, b int) int {
+			if a > b {
+				return a
+			}
+			return b
+		})
+	is.NoErr(err)
+	is.Equal(max, 999)
+
+	cnt, err := sled.ParallelMapReduce(context.Background(), sled.NewHashMap[int, int](), 4, 0,
+		func(k, v int) int { return 1 },
+		func(a, b int) int { return a + b })
+	is.NoErr(err)
+	is.Equal(cnt, 0)
+}
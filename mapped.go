@@ -0,0 +1,697 @@
+package sled
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mappedSled is a read-only sled over a trie file mapped into memory. Keys
+// are found by following the hash trie in place, and values are decoded
+// from the mapping as they are read, so opening the file costs nothing
+// whatever its size, and the pages of the file are shared by every process
+// which maps it.
+type mappedSled struct {
+	t      *trieFile
+	closed int32
+}
+
+// OpenTrie opens a trie file written by WriteTrie as a read-only Sled, by
+// mapping it into memory. Values are decoded by the built-in codec the file
+// names, or by the Codec set by WithCodec if it has the name. Writes to the
+// sled change nothing: those which return an error return ErrReadOnly, and
+// the others report that they did not take place.
+//
+// Keys expire as they would in a sled. Len and Size report the number of
+// keys in the file, including those which have expired. A read-only
+// Snapshot shares the mapping, which is released once the sled and all its
+// snapshots are closed; a read-write Snapshot copies the keys and values
+// into a new in-memory sled.
+//
+// The file has no ordered index. The first of Ascend, Descend, Min, Max,
+// ScanPrefix, ScanGlob and BytesByPrefix reads every key of the file into
+// memory and sorts them, and the sorted keys are kept until the mapping is
+// released, so they cost memory in proportion to the keys of the file.
+//
+// The file must not be truncated or rewritten in place while it is mapped:
+// reading a page past the new end of the file raises SIGBUS, which crashes
+// the program. Replace the file by renaming a new one over it instead.
+func OpenTrie(path string, opts ...Option) (Sled, error) {
+	t, err := openTrieFile(path, newConfig(opts).codec)
+	if err != nil {
+		return nil, err
+	}
+	return &mappedSled{t: t}, nil
+}
+
+// acquire adds a sled sharing the mapping, reporting false if it has been
+// released.
+func (t *trieFile) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.data == nil {
+		return false
+	}
+	t.refs++
+	return true
+}
+
+// release drops a sled sharing the mapping, unmapping the file after the
+// last one.
+func (t *trieFile) release() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.refs--; t.refs > 0 || t.data == nil {
+		return nil
+	}
+	t.data = nil
+	return t.unmap()
+}
+
+// readable returns os.ErrClosed if the sled has been closed. The caller
+// holds m.t.mu.
+func (m *mappedSled) readable() error {
+	if m.t.data == nil || atomic.LoadInt32(&m.closed) == 1 {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+// entry returns the deadline and value of key, if it has not expired.
+func (m *mappedSled) entry(key string, now int64) (at int64, v interface{}, ok bool, err error) {
+	m.t.mu.RLock()
+	defer m.t.mu.RUnlock()
+	if err := m.readable(); err != nil {
+		return 0, nil, false, err
+	}
+	at, v, ok, err = m.t.find(key)
+	if at != 0 && at <= now {
+		return 0, nil, false, err
+	}
+	return at, v, ok, err
+}
+
+func (m *mappedSled) load(key string) (interface{}, bool) {
+	_, v, ok, _ := m.entry(key, time.Now().UnixNano())
+	return v, ok
+}
+
+// Get decodes the value stored for key from the file, and stores it in the
+// value v points to, which must have the type the value was written with.
+func (m *mappedSled) Get(key string, v interface{}) error {
+	_, val, ok, err := m.entry(key, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("key does not exist")
+	}
+	return assign(v, val)
+}
+
+func (m *mappedSled) Set(string, interface{}) error { return ErrReadOnly }
+
+func (m *mappedSled) SetWithTTL(string, interface{}, time.Duration) error { return ErrReadOnly }
+
+func (m *mappedSled) Update(string, UpdateFunc) error { return ErrReadOnly }
+
+func (m *mappedSled) Checkpoint() error { return ErrNotDurable }
+
+func (m *mappedSled) Expire(string, time.Duration) bool { return false }
+
+func (m *mappedSled) SetIfNil(string, interface{}) bool { return false }
+
+func (m *mappedSled) TrySetIfNil(string, interface{}) (bool, error) { return false, ErrReadOnly }
+
+func (m *mappedSled) CompareAndSwap(key string, old, new interface{}) bool { return false }
+
+func (m *mappedSled) CompareAndDelete(key string, old interface{}) bool { return false }
+
+func (m *mappedSled) Delete(string) (interface{}, bool) { return nil, false }
+
+func (m *mappedSled) TryDelete(string) (interface{}, bool, error) { return nil, false, ErrReadOnly }
+
+// GetOrSet returns the value stored for key and true. If the key is not set
+// it returns nil and false, but stores nothing.
+func (m *mappedSled) GetOrSet(key string, _ interface{}) (interface{}, bool) {
+	return m.load(key)
+}
+
+// Close releases the sled's hold on the mapping of the file. Reads from a
+// closed sled fail with os.ErrClosed.
+func (m *mappedSled) Close() error {
+	if !atomic.CompareAndSwapInt32(&m.closed, 0, 1) {
+		return nil
+	}
+	return m.t.release()
+}
+
+// Snapshot returns a read-only sled sharing the mapping of the file, or a
+// read-write in-memory copy of its keys and values.
+func (m *mappedSled) Snapshot(mode IoMode) Sled {
+	if mode == ReadOnly {
+		snap := &mappedSled{t: m.t}
+		if !m.t.acquire() {
+			snap.closed = 1
+		}
+		return snap
+	}
+	s := New(WithCodec(m.t.codec)).(*sled)
+	var pending []deadline
+	c := m.cursor(m.t.root)
+	for c.next() {
+		if c.live {
+			s.restore(c.key, c.value, c.at, &pending)
+		}
+	}
+	for _, d := range pending {
+		s.expireAt(d.key, d.at)
+	}
+	return s
+}
+
+func (m *mappedSled) Size() uint   { return uint(m.t.count) }
+func (m *mappedSled) Len() uint    { return uint(m.t.count) }
+func (m *mappedSled) Bytes() int64 { return m.t.bytes }
+
+// BytesByPrefix estimates the memory the keys under each prefix and their
+// values would hold in a sled.
+func (m *mappedSled) BytesByPrefix(prefixes ...string) map[string]int64 {
+	now := time.Now().UnixNano()
+	keys := m.sortedKeys()
+	sizes := make(map[string]int64, len(prefixes))
+	for _, prefix := range prefixes {
+		var size int64
+		end := prefixEnd(prefix)
+		for i := sort.SearchStrings(keys, prefix); i < len(keys) && (end == "" || keys[i] < end); i++ {
+			at, v, ok, _ := m.entry(keys[i], now)
+			if !ok {
+				continue
+			}
+			if at != 0 {
+				v = &wrapped{value: v, deadline: at}
+			}
+			size += entrySize(keys[i], v)
+		}
+		sizes[prefix] = size
+	}
+	return sizes
+}
+
+// trieCursor visits the entries of a trie file in the order of their
+// hashes, as a cursor does those of a Ctrie. It reads the mapping only while
+// holding its lock, and copies what it reads.
+type trieCursor struct {
+	m   *mappedSled
+	now int64
+	raw bool // leave values encoded, in encoded
+
+	// stack holds the offsets of the children yet to visit of each node on
+	// the path to the current leaf, and entries the entries yet to visit
+	// of the leaf.
+	stack   [][]byte
+	entries []byte
+
+	key     string
+	at      int64
+	live    bool
+	value   interface{}
+	encoded []byte
+	err     error
+}
+
+// cursor returns a cursor over the subtrie at off.
+func (m *mappedSled) cursor(off uint64) *trieCursor {
+	c := &trieCursor{m: m, now: time.Now().UnixNano()}
+	c.stack = append(c.stack, binary.LittleEndian.AppendUint64(nil, off))
+	return c
+}
+
+// next advances to the next entry, expired or not. The values of expired
+// entries are not decoded.
+func (c *trieCursor) next() bool {
+	if c.err != nil {
+		return false
+	}
+	t := c.m.t
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if c.err = c.m.readable(); c.err != nil {
+		return false
+	}
+	for {
+		if len(c.entries) > 0 {
+			key, at, value, n := readEntry(c.entries)
+			if n <= 0 {
+				c.err = ErrCorruptTrie
+				return false
+			}
+			c.entries = c.entries[n:]
+			c.key, c.at, c.value = key, at, nil
+			c.live = at == 0 || at > c.now
+			switch {
+			case !c.live:
+			case c.raw:
+				c.encoded = append(c.encoded[:0], value...)
+			default:
+				c.value, c.err = DecodeValue(t.codec, value)
+			}
+			return c.err == nil
+		}
+		if len(c.stack) == 0 {
+			return false
+		}
+		top := c.stack[len(c.stack)-1]
+		if len(top) == 0 {
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+		off := binary.LittleEndian.Uint64(top)
+		c.stack[len(c.stack)-1] = top[8:]
+		if t.isLeaf(off) {
+			c.entries, _, c.err = t.leaf(off)
+		} else {
+			var children []byte
+			_, children, c.err = t.node(off)
+			if len(c.stack) > exp2/w+1 {
+				c.err = ErrCorruptTrie
+			}
+			c.stack = append(c.stack, children)
+		}
+		if c.err != nil {
+			return false
+		}
+	}
+}
+
+// seek returns a cursor over the entries which come after the entry at
+// position index among those with the given hash.
+func (m *mappedSled) seek(hash uint64, index int) *trieCursor {
+	c := &trieCursor{m: m, now: time.Now().UnixNano()}
+	t := m.t
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if c.err = m.readable(); c.err != nil {
+		return c
+	}
+	off := t.root
+	for lev := uint(0); ; lev += w {
+		if t.isLeaf(off) {
+			entries, count, err := t.leaf(off)
+			if err != nil || count == 0 {
+				c.err = err
+				return c
+			}
+			key, _, _, _ := readEntry(entries)
+			switch h := sipHashString(t.k0, t.k1, key); {
+			case hashBefore(hash, h):
+				c.entries = entries
+			case h == hash:
+				for i := 0; i <= index && len(entries) > 0; i++ {
+					_, _, _, n := readEntry(entries)
+					if n <= 0 {
+						c.err = ErrCorruptTrie
+						return c
+					}
+					entries = entries[n:]
+				}
+				c.entries = entries
+			}
+			return c
+		}
+		if lev >= exp2 {
+			c.err = ErrCorruptTrie
+			return c
+		}
+		bmp, children, err := t.node(off)
+		if err != nil {
+			c.err = err
+			return c
+		}
+		flag, pos := flagPos(hash, lev, bmp)
+		if bmp&flag == 0 {
+			c.stack = append(c.stack, children[8*pos:])
+			return c
+		}
+		c.stack = append(c.stack, children[8*(pos+1):])
+		off = binary.LittleEndian.Uint64(children[8*pos:])
+	}
+}
+
+// mappedIter is an Iterator over the live entries of a trieCursor.
+type mappedIter struct {
+	c   *trieCursor
+	ctx context.Context
+	err error
+}
+
+func (it *mappedIter) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.ctx != nil {
+		select {
+		case <-it.ctx.Done():
+			it.err = ErrCanceled{it.ctx.Err()}
+			return false
+		default:
+		}
+	}
+	for it.c.next() {
+		if it.c.live {
+			return true
+		}
+	}
+	it.err = it.c.err
+	return false
+}
+
+func (it *mappedIter) Key() string        { return it.c.key }
+func (it *mappedIter) Value() interface{} { return it.c.value }
+func (it *mappedIter) Err() error         { return it.err }
+
+// Iterator returns a cursor over the keys of the file. Err reports the
+// error which stopped it early, if the file is damaged or closed.
+func (m *mappedSled) Iterator() Iterator {
+	return &mappedIter{c: m.cursor(m.t.root)}
+}
+
+// IterateContext returns a cursor over the keys of the file, which stops
+// once ctx is done.
+func (m *mappedSled) IterateContext(ctx context.Context) Iterator {
+	return &mappedIter{c: m.cursor(m.t.root), ctx: ctx}
+}
+
+// pageIter is an Iterator over a page of entries.
+type pageIter struct {
+	keys   []string
+	values []interface{}
+	i      int
+}
+
+func (it *pageIter) Next() bool {
+	if it.i >= len(it.keys) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *pageIter) Key() string {
+	if it.i == 0 {
+		return ""
+	}
+	return it.keys[it.i-1]
+}
+
+func (it *pageIter) Value() interface{} {
+	if it.i == 0 {
+		return nil
+	}
+	return it.values[it.i-1]
+}
+
+func (it *pageIter) Err() error { return nil }
+
+// IterateFrom returns a cursor over the next limit entries of the file after
+// the position token, and the Token which resumes after them, as
+// HashMap.IterateFrom does. A page holds fewer than limit entries if some of
+// its keys have expired.
+func (m *mappedSled) IterateFrom(token Token, limit int) (Iterator, Token, error) {
+	var (
+		src   *trieCursor
+		hash  uint64
+		index = -1
+	)
+	if token == "" {
+		src = m.cursor(m.t.root)
+	} else {
+		var err error
+		if hash, index, err = token.decode(); err != nil {
+			return nil, "", err
+		}
+		src = m.seek(hash, index)
+	}
+	page := &pageIter{}
+	for n := 0; limit <= 0 || n < limit; n++ {
+		if !src.next() {
+			return page, "", src.err
+		}
+		// Entries which share a hash are only found together in a leaf,
+		// so they are visited one after another.
+		if h := sipHashString(m.t.k0, m.t.k1, src.key); index >= 0 && h == hash {
+			index++
+		} else {
+			hash, index = h, 0
+		}
+		if src.live {
+			page.keys = append(page.keys, src.key)
+			page.values = append(page.values, src.value)
+		}
+	}
+	if !src.next() {
+		return page, "", src.err
+	}
+	return page, makeToken(hash, index), nil
+}
+
+// Range calls fn for each key and value of the file, until fn returns false
+// or ctx is done. If ctx stops the iteration Range returns an ErrCanceled
+// wrapping ctx.Err().
+func (m *mappedSled) Range(ctx context.Context, fn func(key string, value interface{}) bool) error {
+	it := m.IterateContext(ctx)
+	for it.Next() {
+		if !fn(it.Key(), it.Value()) {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+// ParallelRange calls fn for each key and value of the file from up to
+// workers goroutines at once, handing out the subtries under the root node
+// as HashMap.ParallelRange hands out those of a Ctrie.
+func (m *mappedSled) ParallelRange(ctx context.Context, workers int, fn func(key string, value interface{}) bool) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var branches []uint64
+	m.t.mu.RLock()
+	err := m.readable()
+	if err == nil {
+		var children []byte
+		_, children, err = m.t.node(m.t.root)
+		for ; len(children) > 0; children = children[8:] {
+			branches = append(branches, binary.LittleEndian.Uint64(children))
+		}
+	}
+	m.t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var (
+		next    int64 = -1
+		stopped int32
+		errOnce sync.Once
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if n >= int64(len(branches)) || atomic.LoadInt32(&stopped) == 1 {
+					return
+				}
+				it := &mappedIter{c: m.cursor(branches[n]), ctx: ctx}
+				for it.Next() {
+					if atomic.LoadInt32(&stopped) == 1 {
+						return
+					}
+					if !fn(it.Key(), it.Value()) {
+						atomic.StoreInt32(&stopped, 1)
+						return
+					}
+				}
+				if it.Err() != nil {
+					errOnce.Do(func() {
+						err = it.Err()
+					})
+					atomic.StoreInt32(&stopped, 1)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+// All returns an iterator over the keys and values of the file, for use
+// with range.
+func (m *mappedSled) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		it := m.Iterator()
+		for it.Next() {
+			if !yield(it.Key(), it.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns an iterator over the keys of the file, for use with range.
+func (m *mappedSled) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Iterate sends an Element for each key of the file on the returned
+// channel, until cancel is closed. Elements must be closed after use.
+func (m *mappedSled) Iterate(cancel <-chan struct{}) <-chan Element {
+	out := make(chan Element)
+	go func() {
+		defer close(out)
+		it := m.Iterator()
+		for it.Next() {
+			entry := elePool.Get().(*ele)
+			entry.k = it.Key()
+			entry.v = it.Value()
+			entry.c = func() {
+				elePool.Put(entry)
+			}
+			select {
+			case out <- entry:
+			case <-cancel:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// sortedKeys returns the keys of the file in order. They are read into
+// memory and sorted on the first ordered scan of the file, since the trie is
+// ordered by hash.
+func (m *mappedSled) sortedKeys() []string {
+	m.t.sorted.once.Do(func() {
+		keys := make([]string, 0, m.t.count)
+		c := m.cursor(m.t.root)
+		c.raw = true
+		for c.next() {
+			keys = append(keys, c.key)
+		}
+		sort.Strings(keys)
+		m.t.sorted.keys = keys
+	})
+	return m.t.sorted.keys
+}
+
+// Ascend returns an iterator over the keys from from up to but not including
+// to, in ascending order, and their values. An empty to means no upper bound.
+// The keys of the file are sorted by its first ordered scan.
+func (m *mappedSled) Ascend(from, to string) iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		keys := m.sortedKeys()
+		for i := sort.SearchStrings(keys, from); i < len(keys) && (to == "" || keys[i] < to); i++ {
+			if v, ok := m.load(keys[i]); ok && !yield(keys[i], v) {
+				return
+			}
+		}
+	}
+}
+
+// Descend returns an iterator over the keys less than from down to and
+// including to, in descending order, and their values. An empty from means
+// no upper bound.
+func (m *mappedSled) Descend(from, to string) iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		keys := m.sortedKeys()
+		i := len(keys)
+		if from != "" {
+			i = sort.SearchStrings(keys, from)
+		}
+		for i--; i >= 0 && keys[i] >= to; i-- {
+			if v, ok := m.load(keys[i]); ok && !yield(keys[i], v) {
+				return
+			}
+		}
+	}
+}
+
+// Min returns the smallest key and its value, or false if the file is empty.
+func (m *mappedSled) Min() (key string, value interface{}, ok bool) {
+	for key, value = range m.Ascend("", "") {
+		return key, value, true
+	}
+	return "", nil, false
+}
+
+// Max returns the largest key and its value, or false if the file is empty.
+func (m *mappedSled) Max() (key string, value interface{}, ok bool) {
+	for key, value = range m.Descend("", "") {
+		return key, value, true
+	}
+	return "", nil, false
+}
+
+// ScanPrefix returns an iterator over the keys which begin with prefix, in
+// order, and their values.
+func (m *mappedSled) ScanPrefix(prefix string) iter.Seq2[string, interface{}] {
+	return m.Ascend(prefix, prefixEnd(prefix))
+}
+
+// ScanGlob returns an iterator over the keys which match pattern, and their
+// values. See sled.ScanGlob.
+func (m *mappedSled) ScanGlob(pattern string) (iter.Seq2[string, interface{}], error) {
+	return scanGlob(pattern, m.ScanPrefix)
+}
+
+// WriteTo writes the keys and values of the file to w in the snapshot file
+// format read by Load. The values are copied as they are encoded in the
+// file.
+func (m *mappedSled) WriteTo(w io.Writer) (int64, error) {
+	var count uint64
+	c := m.cursor(m.t.root)
+	c.raw = true
+	for c.next() {
+		if c.live {
+			count++
+		}
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	sw := newSnapshotWriter(w, m.t.codec.Name(), count)
+	now := c.now
+	c = m.cursor(m.t.root)
+	c.raw = true
+	c.now = now
+	for c.next() {
+		if !c.live {
+			continue
+		}
+		if err := sw.add(c.key, c.at, c.encoded); err != nil {
+			return sw.cw.n, err
+		}
+	}
+	if c.err != nil {
+		return sw.cw.n, c.err
+	}
+	return sw.close()
+}
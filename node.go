@@ -1,31 +1,34 @@
 package sled
 
 import (
-	"bytes"
 	"sync/atomic"
 	"unsafe"
 )
 
 // node is either a cNode, tNode, lNode, or failed node which makes up an
 // I-node.
-type node struct {
-	cNode  *cNode
-	tNode  *tNode
-	lNode  *lNode
-	failed *node
+type node[K, V any] struct {
+	cNode  *cNode[K, V]
+	tNode  *tNode[K, V]
+	lNode  *lNode[K, V]
+	failed *node[K, V]
 
 	// prev is set as a failed main node when we attempt to CAS and the
 	// I-node's generation does not match the root generation. This signals
 	// that the GCAS failed and the I-node's main node must be set back to the
 	// previous value.
-	prev *node
+	prev *node[K, V]
+
+	// size caches the number of keys below this node, plus one, once it is
+	// computed in a read-only Ctrie. Zero means the size is not known yet.
+	size uint64
 }
 
 // newNode is a recursive constructor which creates a new node. This
 // node will consist of cNodes as long as the hashcode chunks of the two
 // keys are equal at the given level. If the level exceeds 2^w, an lNode is
 // created.
-func newNode(x *sNode, xhc uint64, y *sNode, yhc uint64, lev uint, gen *generation) *node {
+func newNode[K, V any](x *sNode[K, V], xhc uint64, y *sNode[K, V], yhc uint64, lev uint, gen *generation) *node[K, V] {
 	if lev < exp2 {
 		xidx := (xhc >> lev) & 0x3f
 		yidx := (yhc >> lev) & 0x3f
@@ -34,34 +37,34 @@ func newNode(x *sNode, xhc uint64, y *sNode, yhc uint64, lev uint, gen *generati
 		if xidx == yidx {
 			// Recurse when indexes are equal.
 			main := newNode(x, xhc, y, yhc, lev+w, gen)
-			iNode := &iNode{main: main, gen: gen}
-			return &node{cNode: &cNode{bmp, []branch{iNode}, gen}}
+			iNode := &iNode[K, V]{main: main, gen: gen}
+			return &node[K, V]{cNode: &cNode[K, V]{bmp, []branch{iNode}, gen}}
 		}
 		if xidx < yidx {
-			return &node{cNode: &cNode{bmp, []branch{x, y}, gen}}
+			return &node[K, V]{cNode: &cNode[K, V]{bmp, []branch{x, y}, gen}}
 		}
-		return &node{cNode: &cNode{bmp, []branch{y, x}, gen}}
+		return &node[K, V]{cNode: &cNode[K, V]{bmp, []branch{y, x}, gen}}
 	}
 	l := emptyList.Add(x).Add(y)
-	return &node{lNode: &lNode{l}}
+	return &node[K, V]{lNode: &lNode[K, V]{l}}
 }
 
 // iNode is an indirection node. I-nodes remain present in the Ctrie even as
 // nodes above and below change. Thread-safety is achieved in part by
 // performing CAS operations on the I-node instead of the internal node array.
-type iNode struct {
-	main *node
+type iNode[K, V any] struct {
+	main *node[K, V]
 	gen  *generation
 
 	// rdcss is set during an RDCSS operation. The I-node is actually a wrapper
 	// around the descriptor in this case so that a single type is used during
 	// CAS operations on the root.
-	rdcss *rdcssDescriptor
+	rdcss *rdcssDescriptor[K, V]
 }
 
 // copyToGen returns a copy of this I-node copied to the given generation.
-func (i *iNode) copyToGen(gen *generation, c *ctrie) *iNode {
-	nin := &iNode{gen: gen}
+func (i *iNode[K, V]) copyToGen(gen *generation, c *ctrie[K, V]) *iNode[K, V] {
+	nin := &iNode[K, V]{gen: gen}
 	main := gcasRead(i, c)
 	atomic.StorePointer(
 		(*unsafe.Pointer)(unsafe.Pointer(&nin.main)), unsafe.Pointer(main))
@@ -71,7 +74,7 @@ func (i *iNode) copyToGen(gen *generation, c *ctrie) *iNode {
 // cNode is an internal main node containing a bitmap and the array with
 // references to branch nodes. A branch node is either another I-node or a
 // singleton S-node.
-type cNode struct {
+type cNode[K, V any] struct {
 	bmp   uint64
 	array []branch
 	gen   *generation
@@ -79,7 +82,7 @@ type cNode struct {
 
 // inserted returns a copy of this cNode with the new entry at the given
 // position.
-func (c *cNode) inserted(pos, flag uint64, br branch, gen *generation) *cNode {
+func (c *cNode[K, V]) inserted(pos, flag uint64, br branch, gen *generation) *cNode[K, V] {
 	length := uint64(len(c.array))
 	bmp := c.bmp
 	array := make([]branch, length+1)
@@ -89,23 +92,23 @@ func (c *cNode) inserted(pos, flag uint64, br branch, gen *generation) *cNode {
 		array[i+1] = c.array[i]
 		x++
 	}
-	ncn := &cNode{bmp: bmp | flag, array: array, gen: gen}
+	ncn := &cNode[K, V]{bmp: bmp | flag, array: array, gen: gen}
 	return ncn
 }
 
 // updated returns a copy of this cNode with the entry at the given index
 // updated.
-func (c *cNode) updated(pos uint64, br branch, gen *generation) *cNode {
+func (c *cNode[K, V]) updated(pos uint64, br branch, gen *generation) *cNode[K, V] {
 	array := make([]branch, len(c.array))
 	copy(array, c.array)
 	array[pos] = br
-	ncn := &cNode{bmp: c.bmp, array: array, gen: gen}
+	ncn := &cNode[K, V]{bmp: c.bmp, array: array, gen: gen}
 	return ncn
 }
 
 // removed returns a copy of this cNode with the entry at the given index
 // removed.
-func (c *cNode) removed(pos, flag uint64, gen *generation) *cNode {
+func (c *cNode[K, V]) removed(pos, flag uint64, gen *generation) *cNode[K, V] {
 	length := uint64(len(c.array))
 	bmp := c.bmp
 	array := make([]branch, length-1)
@@ -116,11 +119,11 @@ func (c *cNode) removed(pos, flag uint64, gen *generation) *cNode {
 		array[i] = c.array[i+1]
 		x++
 	}
-	ncn := &cNode{bmp: bmp ^ flag, array: array, gen: gen}
+	ncn := &cNode[K, V]{bmp: bmp ^ flag, array: array, gen: gen}
 	return ncn
 }
 
-func (n *cNode) renewif(gen *generation, c *ctrie) *cNode {
+func (n *cNode[K, V]) renewif(gen *generation, c *ctrie[K, V]) *cNode[K, V] {
 	if n.gen != gen {
 		return n.renewed(gen, c)
 	}
@@ -129,77 +132,89 @@ func (n *cNode) renewif(gen *generation, c *ctrie) *cNode {
 
 // renewed returns a copy of this cNode with the I-nodes below it copied to the
 // given generation.
-func (n *cNode) renewed(gen *generation, c *ctrie) *cNode {
+func (n *cNode[K, V]) renewed(gen *generation, c *ctrie[K, V]) *cNode[K, V] {
 	array := make([]branch, len(n.array))
 	for i, br := range n.array {
 		switch t := br.(type) {
-		case *iNode:
+		case *iNode[K, V]:
 			array[i] = t.copyToGen(gen, c)
 		default:
 			array[i] = br
 		}
 	}
-	return &cNode{bmp: n.bmp, array: array, gen: gen}
+	return &cNode[K, V]{bmp: n.bmp, array: array, gen: gen}
 }
 
 // tNode is tomb node which is a special node used to ensure proper ordering
 // during removals.
-type tNode struct {
-	*sNode
+type tNode[K, V any] struct {
+	*sNode[K, V]
 }
 
 // untombed returns the S-node contained by the T-node.
-func (t *tNode) untombed() *sNode {
-	return &sNode{&entry{Key: t.Key, hash: t.hash, Value: t.Value}}
+func (t *tNode[K, V]) untombed() *sNode[K, V] {
+	return &sNode[K, V]{&entry[K, V]{Key: t.Key, hash: t.hash, Value: t.Value}}
 }
 
 // lNode is a list node which is a leaf node used to handle hashcode
 // collisions by keeping such keys in a persistent list.
-type lNode struct {
+type lNode[K, V any] struct {
 	lister
 }
 
 // entry returns the first S-node contained in the L-node.
-func (l *lNode) entry() *sNode {
+func (l *lNode[K, V]) entry() *sNode[K, V] {
 	head, _ := l.Head()
-	return head.(*sNode)
+	return head.(*sNode[K, V])
 }
 
 // lookup returns the value at the given entry in the L-node or returns false
 // if it's not contained.
-func (l *lNode) lookup(e *entry) (interface{}, bool) {
-	found, ok := l.Find(func(sn interface{}) bool {
-		return bytes.Equal(e.Key, sn.(*sNode).Key)
-	})
-	if !ok {
-		return nil, false
+func (l *lNode[K, V]) lookup(e *entry[K, V], keys keyer[K]) (V, bool) {
+	var zero V
+	for ls := l.lister; !ls.IsEmpty(); ls, _ = ls.Tail() {
+		head, _ := ls.Head()
+		if sn := head.(*sNode[K, V]); keys.equal(e.Key, sn.Key) {
+			return sn.Value, true
+		}
 	}
-	return found.(*sNode).Value, true
+	return zero, false
 }
 
-// inserted creates a new L-node with the added entry.
-func (l *lNode) inserted(e *entry) *lNode {
-	return &lNode{l.Add(&sNode{e})}
+// inserted creates a new L-node with the added entry, replacing any entry
+// with the same key.
+func (l *lNode[K, V]) inserted(e *entry[K, V], keys keyer[K]) *lNode[K, V] {
+	return &lNode[K, V]{l.removed(e, keys).Add(&sNode[K, V]{e})}
 }
 
 // removed creates a new L-node with the entry removed.
-func (l *lNode) removed(e *entry) *lNode {
+func (l *lNode[K, V]) removed(e *entry[K, V], keys keyer[K]) *lNode[K, V] {
 	idx := l.FindIndex(func(sn interface{}) bool {
-		return bytes.Equal(e.Key, sn.(*sNode).Key)
+		return keys.equal(e.Key, sn.(*sNode[K, V]).Key)
 	})
 	if idx < 0 {
 		return l
 	}
 	nl, _ := l.Remove(uint(idx))
-	return &lNode{nl}
+	return &lNode[K, V]{nl}
+}
+
+// removedNode returns the main node which replaces this L-node once the entry
+// is removed. An L-node left with a single entry is entombed.
+func (l *lNode[K, V]) removedNode(e *entry[K, V], keys keyer[K]) *node[K, V] {
+	nl := l.removed(e, keys)
+	if nl.length() == 1 {
+		return entomb(nl.entry())
+	}
+	return &node[K, V]{lNode: nl}
 }
 
 // length returns the L-node list length.
-func (l *lNode) length() uint {
+func (l *lNode[K, V]) length() uint {
 	return l.Length()
 }
 
 // sNode is a singleton node which contains a single key and value.
-type sNode struct {
-	*entry
+type sNode[K, V any] struct {
+	*entry[K, V]
 }
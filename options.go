@@ -0,0 +1,170 @@
+package sled
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash"
+	"time"
+)
+
+// Option configures a sled or Map created by New or NewMap.
+type Option func(*config)
+
+type config struct {
+	hashFactory        hasher
+	hashFunc           func(key string) uint64
+	seed               []byte
+	ordered            bool
+	maxEntries         uint
+	maxBytes           int64
+	policy             EvictionPolicy
+	onEvict            func(key string, value interface{})
+	syncPolicy         SyncPolicy
+	syncInterval       time.Duration
+	segmentSize        int64
+	codec              Codec
+	checkpointInterval time.Duration
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// keys returns the keyer for string keys described by the configuration.
+func (cfg *config) keys() keyer[string] {
+	if cfg.hashFunc != nil {
+		return stringKeyer{cfg.hashFunc}
+	}
+	return seededStringKeys(cfg.hashFactory, cfg.seed)
+}
+
+// WithHasher sets the factory for the Hash64 used to hash keys. By default
+// keys are hashed with SipHash-2-4 under a random per-process key, which
+// resists hash flooding. Pass fnv.New64a for the faster, unkeyed FNV-1a.
+func WithHasher(factory func() hash.Hash64) Option {
+	return func(cfg *config) {
+		cfg.hashFactory = factory
+	}
+}
+
+// WithHashFunc sets a function used to hash keys. Unlike a Hash64 factory,
+// a hash function needs no per key state, so hashing can be done without
+// allocating. It takes precedence over WithHasher, and is not seeded.
+func WithHashFunc(fn func(key string) uint64) Option {
+	return func(cfg *config) {
+		cfg.hashFunc = fn
+	}
+}
+
+// WithSeed mixes seed into the hash of every key, so the placement of keys
+// in the trie differs between stores with different seeds.
+func WithSeed(seed uint64) Option {
+	return func(cfg *config) {
+		cfg.seed = binary.LittleEndian.AppendUint64(nil, seed)
+	}
+}
+
+// WithRandomSeed seeds key hashing with a random value chosen when the store
+// is created. See WithSeed.
+func WithRandomSeed() Option {
+	return func(cfg *config) {
+		cfg.seed = make([]byte, 8)
+		rand.Read(cfg.seed)
+	}
+}
+
+// WithOrdered keeps the keys of a sled in a concurrent sorted index from the
+// start. Other sleds build the index on their first ordered or prefix scan,
+// which then reads every key once. Either way Ascend, Descend, Min, Max and
+// the prefix scans seek straight to their keys, and every write updates the
+// index from then on. Read-only snapshots taken once the index is built scan
+// it too, rather than sorting their keys. It has no effect on a Map.
+func WithOrdered() Option {
+	return func(cfg *config) {
+		cfg.ordered = true
+	}
+}
+
+// WithMaxEntries bounds a sled to n keys. Writes which take it over the
+// bound evict keys chosen by its EvictionPolicy, by default LRU. It has no
+// effect on a Map.
+func WithMaxEntries(n uint) Option {
+	return func(cfg *config) {
+		cfg.maxEntries = n
+	}
+}
+
+// WithMaxBytes bounds the estimated memory held by the keys and values of a
+// sled, as reported by Bytes, to b bytes. Each entry is measured once, when
+// it is written. Writes which take the sled over the bound evict keys chosen
+// by its EvictionPolicy, by default LRU. It has no effect on a Map.
+func WithMaxBytes(b int64) Option {
+	return func(cfg *config) {
+		cfg.maxBytes = b
+	}
+}
+
+// WithEvictionPolicy sets the policy which chooses the keys a bounded sled
+// evicts. A policy holds the state of one sled, and must not be shared.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(cfg *config) {
+		cfg.policy = p
+	}
+}
+
+// WithOnEvict sets a function called with each key and value a bounded sled
+// evicts. It is called synchronously by the write which caused the
+// eviction, but without any lock of the sled held, so it may itself write
+// to the sled.
+func WithOnEvict(fn func(key string, value interface{})) Option {
+	return func(cfg *config) {
+		cfg.onEvict = fn
+	}
+}
+
+// WithSyncPolicy sets when a durable sled created by Open flushes its log to
+// stable storage. The default is SyncAlways.
+func WithSyncPolicy(p SyncPolicy) Option {
+	return func(cfg *config) {
+		cfg.syncPolicy = p
+	}
+}
+
+// WithSyncInterval flushes the log of a durable sled every d, as SyncInterval.
+// The default interval is one second.
+func WithSyncInterval(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.syncPolicy = SyncInterval
+		cfg.syncInterval = d
+	}
+}
+
+// WithSegmentSize sets the size in bytes at which a durable sled starts a new
+// segment of its log. The default is 64 MiB.
+func WithSegmentSize(n int64) Option {
+	return func(cfg *config) {
+		cfg.segmentSize = n
+	}
+}
+
+// WithCodec sets the Codec which encodes values in the log of a durable sled
+// and in snapshot files. The default is GobCodec.
+func WithCodec(c Codec) Option {
+	return func(cfg *config) {
+		cfg.codec = c
+	}
+}
+
+// WithCheckpointInterval checkpoints a durable sled created by Open every d,
+// in the background, dropping the log the checkpoint replaces. See
+// Checkpoint. By default a sled is only checkpointed when Checkpoint is
+// called.
+func WithCheckpointInterval(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.checkpointInterval = d
+	}
+}
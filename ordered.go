@@ -0,0 +1,315 @@
+package sled
+
+import (
+	"iter"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// index is the ordered index of the keys of a sled, with a log of the keys
+// removed from it. A read-only snapshot scans the index of the sled it was
+// taken from, and finds the keys removed from it since in the log.
+type index struct {
+	*skiplist
+
+	// removed is the last entry of the log. Entries are only linked
+	// forward, so those older than every snapshot are garbage.
+	removed atomic.Pointer[removal]
+
+	// filled is set once the index holds every key of the sled, which
+	// snapshots wait for before they use it.
+	filled atomic.Bool
+}
+
+// removal is an entry of the log of keys removed from an index.
+type removal struct {
+	key  string
+	next atomic.Pointer[removal]
+}
+
+func newIndex() *index {
+	idx := &index{skiplist: newSkiplist()}
+	idx.removed.Store(&removal{})
+	return idx
+}
+
+// drop logs key, then removes it from the index. A snapshot which misses
+// the key in the index is sure to find it in the log.
+func (idx *index) drop(key string) {
+	r := &removal{key: key}
+	for {
+		last := idx.removed.Load()
+		if last.next.CompareAndSwap(nil, r) {
+			idx.removed.CompareAndSwap(last, r)
+			break
+		}
+		// Help the append which got there first.
+		idx.removed.CompareAndSwap(last, last.next.Load())
+	}
+	idx.remove(key)
+}
+
+// view is how a read-only snapshot scans its keys in order: through the
+// index of the sled it was taken from, with the keys logged as removed from
+// it after since, or by sorting its keys if that sled had no filled index.
+type view struct {
+	idx    *index
+	since  *removal
+	sorted sortedKeys
+}
+
+// sortedKeys holds keys sorted on first use.
+type sortedKeys struct {
+	once sync.Once
+	keys []string
+}
+
+// newView returns the view of a read-only snapshot of the sled, which is
+// taken after it returns: the log must start before the snapshot.
+func (s *sled) newView() *view {
+	if s.view != nil {
+		return s.view
+	}
+	v := &view{}
+	if idx := s.idx.Load(); idx != nil && idx.filled.Load() {
+		v.idx, v.since = idx, idx.removed.Load()
+	}
+	return v
+}
+
+// sortedKeys returns the keys of a read-only snapshot in order, sorting them
+// on first use.
+func (s *sled) sortedKeys() []string {
+	s.view.sorted.once.Do(func() {
+		s.view.sorted.keys = sortMap(s.m)
+	})
+	return s.view.sorted.keys
+}
+
+// removals gathers the keys of a read-only snapshot which were removed from
+// the index of its source after the snapshot was taken, as a scan of the
+// index goes. A key is logged before it leaves the index, so a key the scan
+// misses in the index has been read from the log by the time the scan has
+// moved past it.
+type removals struct {
+	s    *sled
+	last *removal
+	in   func(key string) bool
+	keys []string // in order
+}
+
+func (s *sled) removals(in func(key string) bool) *removals {
+	return &removals{s: s, last: s.view.since, in: in}
+}
+
+// read adds the keys in range which the snapshot holds, logged since the
+// last read.
+func (rs *removals) read() {
+	for r := rs.last.next.Load(); r != nil; r = r.next.Load() {
+		rs.last = r
+		if !rs.in(r.key) {
+			continue
+		}
+		if _, ok := rs.s.m.Load(r.key); !ok {
+			continue
+		}
+		if i, found := slices.BinarySearch(rs.keys, r.key); !found {
+			rs.keys = slices.Insert(rs.keys, i, r.key)
+		}
+	}
+}
+
+// ordered returns the ordered index of a sled which is not a read-only
+// snapshot, building it on first use. The index is installed before it is
+// filled from a snapshot of the keys, so writes made meanwhile are tracked
+// in it too.
+func (s *sled) ordered() *index {
+	if idx := s.idx.Load(); idx != nil {
+		return idx
+	}
+	s.idxOnce.Do(func() {
+		idx := newIndex()
+		s.idx.Store(idx)
+		for k := range s.m.Snapshot(ReadOnly).Keys() {
+			idx.insert(k)
+		}
+		idx.filled.Store(true)
+	})
+	return s.idx.Load()
+}
+
+func sortMap(m *Map[interface{}]) []string {
+	keys := make([]string, 0, m.Len())
+	for k := range m.Keys() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unindex removes key, which a scan found in the ordered index but not in
+// the trie. Such a key was left behind by a remove racing a write of it, or
+// the building of the index. It is put back if a write stored it meanwhile,
+// as track does.
+func (s *sled) unindex(idx *index, key string) {
+	idx.drop(key)
+	if _, ok := s.m.Load(key); ok {
+		idx.insert(key)
+	}
+}
+
+// Ascend returns an iterator over the keys from from up to but not including
+// to, in ascending order, and their values. An empty to means no upper bound.
+//
+// A sled keeps its keys in a concurrent skiplist, built by its first ordered
+// scan unless it was created WithOrdered, and scans it as it goes. Like
+// Iterate on a live sled such a scan is weakly consistent: each value is
+// current when it is read, and keys written during the scan may or may not
+// be seen.
+//
+// A read-only snapshot scans the skiplist of the sled it was taken from,
+// skipping the keys it does not hold, together with the keys removed from the
+// skiplist since. Those are read from a log of removals, whose entries are
+// kept for as long as a snapshot older than them is. Its scans visit exactly
+// its keys, but for a key whose write was still in progress when the
+// snapshot was taken. A snapshot of a sled which had no skiplist yet sorts
+// its keys on its first ordered scan instead.
+func (s *sled) Ascend(from, to string) iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		yield = liveFunc(s.clock(), yield)
+		in := func(key string) bool {
+			return key >= from && (to == "" || key < to)
+		}
+		switch {
+		case s.view == nil:
+			idx := s.ordered()
+			for n := idx.ceiling(from); n != nil && in(n.key); n = n.successor() {
+				v, ok := s.m.Load(n.key)
+				if !ok {
+					s.unindex(idx, n.key)
+					continue
+				}
+				if !yield(n.key, v) {
+					return
+				}
+			}
+		case s.view.idx != nil:
+			// Keys are yielded in order from the index and the log, up to
+			// the position of the index.
+			emit := func(key string) bool {
+				v, ok := s.m.Load(key)
+				return !ok || yield(key, v)
+			}
+			rs := s.removals(in)
+			for n := s.view.idx.ceiling(from); ; n = n.successor() {
+				rs.read()
+				end := n == nil || !in(n.key)
+				for len(rs.keys) > 0 && (end || rs.keys[0] <= n.key) {
+					key := rs.keys[0]
+					rs.keys = rs.keys[1:]
+					if (end || key != n.key) && !emit(key) {
+						return
+					}
+				}
+				if end {
+					return
+				}
+				if !emit(n.key) {
+					return
+				}
+				// Keys logged from now on which come before n were
+				// seen in the index.
+				rs.in = func(key string) bool { return in(key) && key > n.key }
+			}
+		default:
+			keys := s.sortedKeys()
+			for i := sort.SearchStrings(keys, from); i < len(keys) && in(keys[i]); i++ {
+				v, _ := s.m.Load(keys[i])
+				if !yield(keys[i], v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Descend returns an iterator over the keys less than from down to and
+// including to, in descending order, and their values. It visits the keys of
+// Ascend(to, from) in reverse. An empty from means no upper bound.
+func (s *sled) Descend(from, to string) iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		yield = liveFunc(s.clock(), yield)
+		in := func(key string) bool {
+			return key >= to && (from == "" || key < from)
+		}
+		switch {
+		case s.view == nil:
+			idx := s.ordered()
+			for n := idx.lower(from, from == ""); n != nil && in(n.key); n = idx.lower(n.key, false) {
+				v, ok := s.m.Load(n.key)
+				if !ok {
+					s.unindex(idx, n.key)
+					continue
+				}
+				if !yield(n.key, v) {
+					return
+				}
+			}
+		case s.view.idx != nil:
+			emit := func(key string) bool {
+				v, ok := s.m.Load(key)
+				return !ok || yield(key, v)
+			}
+			idx := s.view.idx
+			rs := s.removals(in)
+			for n := idx.lower(from, from == ""); ; n = idx.lower(n.key, false) {
+				rs.read()
+				end := n == nil || !in(n.key)
+				for last := len(rs.keys) - 1; last >= 0 && (end || rs.keys[last] >= n.key); last-- {
+					key := rs.keys[last]
+					rs.keys = rs.keys[:last]
+					if (end || key != n.key) && !emit(key) {
+						return
+					}
+				}
+				if end {
+					return
+				}
+				if !emit(n.key) {
+					return
+				}
+				rs.in = func(key string) bool { return in(key) && key < n.key }
+			}
+		default:
+			keys := s.sortedKeys()
+			i := len(keys)
+			if from != "" {
+				i = sort.SearchStrings(keys, from)
+			}
+			for i--; i >= 0 && in(keys[i]); i-- {
+				v, _ := s.m.Load(keys[i])
+				if !yield(keys[i], v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Min returns the smallest key and its value, or false if the sled is empty.
+func (s *sled) Min() (key string, value interface{}, ok bool) {
+	for key, value = range s.Ascend("", "") {
+		return key, value, true
+	}
+	return "", nil, false
+}
+
+// Max returns the largest key and its value, or false if the sled is empty.
+func (s *sled) Max() (key string, value interface{}, ok bool) {
+	for key, value = range s.Descend("", "") {
+		return key, value, true
+	}
+	return "", nil, false
+}
@@ -0,0 +1,125 @@
+package sled
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// parallelScan calls fn for every entry of a read-only snapshot of the Ctrie
+// from a pool of workers. The branches of the root C-node are independent
+// subtries, so each is handed out whole to the next idle worker. fn is told
+// which worker calls it, so workers can keep private state. Scanning stops
+// once fn returns false or ctx is done, and parallelScan returns only after
+// every worker has exited.
+func parallelScan[K, V any](ctx context.Context, c *ctrie[K, V], workers int, fn func(worker int, key K, value V) bool) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	snapshot := c.Snapshot(ReadOnly)
+	branches := gcasRead(snapshot.readRoot(), snapshot).cNode.array
+
+	var (
+		next    int64 = -1
+		stopped int32
+		errOnce sync.Once
+		err     error
+		wg      sync.WaitGroup
+	)
+	stop := func() {
+		atomic.StoreInt32(&stopped, 1)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if n >= int64(len(branches)) || atomic.LoadInt32(&stopped) == 1 {
+					return
+				}
+				it := &Iter[K, V]{cur: branchCursor(snapshot, branches[n]), ctx: ctx}
+				for it.Next() {
+					if atomic.LoadInt32(&stopped) == 1 {
+						return
+					}
+					if !fn(w, it.Key(), it.Value()) {
+						stop()
+						return
+					}
+				}
+				if it.Err() != nil {
+					errOnce.Do(func() {
+						err = it.Err()
+					})
+					stop()
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return err
+}
+
+// ParallelRange calls fn for each key and value in a point in time image of
+// the HashMap, from up to workers goroutines at once. If workers is not
+// positive, GOMAXPROCS workers are used. fn must be safe for concurrent use.
+// Returning false from fn stops all workers, though calls already under way
+// on other workers complete. If ctx is done before the scan completes,
+// ParallelRange returns an ErrCanceled wrapping ctx.Err().
+func (m *HashMap[K, V]) ParallelRange(ctx context.Context, workers int, fn func(key K, value V) bool) error {
+	return parallelScan(ctx, m.ct, workers, func(_ int, key K, value V) bool {
+		return fn(key, value)
+	})
+}
+
+// ParallelMapReduce aggregates a point in time image of m across up to
+// workers goroutines. Each worker folds mapFn of its entries into its own
+// accumulator, starting from identity, and the accumulators are then folded
+// together. reduceFn must be associative and commutative, as entries reach
+// it in no particular order, and identity must leave values unchanged.
+func ParallelMapReduce[K, V, R any](ctx context.Context, m *HashMap[K, V], workers int, identity R, mapFn func(key K, value V) R, reduceFn func(a, b R) R) (R, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	acc := make([]R, workers)
+	for i := range acc {
+		acc[i] = identity
+	}
+	err := parallelScan(ctx, m.ct, workers, func(w int, key K, value V) bool {
+		acc[w] = reduceFn(acc[w], mapFn(key, value))
+		return true
+	})
+	result := identity
+	for _, a := range acc {
+		result = reduceFn(result, a)
+	}
+	return result, err
+}
+
+// ParallelMapReduceSled is ParallelMapReduce for a Sled. Sleds which are not
+// backed by a ctrie are mapped in parallel through their ParallelRange, with
+// the reduction serialized.
+func ParallelMapReduceSled[R any](ctx context.Context, s Sled, workers int, identity R, mapFn func(key string, value interface{}) R, reduceFn func(a, b R) R) (R, error) {
+	if sl, ok := s.(*sled); ok {
+		now := sl.clock()
+		return ParallelMapReduce(ctx, sl.m.HashMap, workers, identity, func(key string, value interface{}) R {
+			if v, ok := liveAt(value, now); ok {
+				return mapFn(key, v)
+			}
+			return identity
+		}, reduceFn)
+	}
+	var mu sync.Mutex
+	result := identity
+	err := s.ParallelRange(ctx, workers, func(key string, value interface{}) bool {
+		r := mapFn(key, value)
+		mu.Lock()
+		result = reduceFn(result, r)
+		mu.Unlock()
+		return true
+	})
+	return result, err
+}
@@ -0,0 +1,288 @@
+package sled
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// A snapshot file holds the keys and values of a read-only snapshot:
+//
+//	file    = magic version:uint16 codeclen:byte codec count:uint64 block* end
+//	block   = length:uint32 crc:uint32 payload[length]
+//	payload = entry*
+//	entry   = keylen:uvarint key deadline:varint valuelen:uvarint value
+//	end     = length:uint32(0) crc:uint32(0)
+//
+// The magic is "SLEDSNAP", integers are little endian, and crc is the
+// CRC-32C of the payload. count is the number of entries. codec names the
+// encoding of the values, which are encoded by EncodeValue. A deadline is the
+// time at which the key expires, in Unix nanoseconds, or 0 if it does not.
+//
+// In version 1 files the codec is always gob, and each value is a gob stream
+// of an interface holding it, as encoded by gob.Encoder.Encode(&v). They are
+// still read.
+const (
+	snapshotMagic   = "SLEDSNAP"
+	snapshotVersion = 2
+	snapshotBlock   = 64 << 10
+)
+
+// ErrInvalidSnapshot is returned by Load when its input is not a snapshot
+// file written by WriteTo, or is damaged.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteTo writes the keys and values of a read-only snapshot to w, in the
+// snapshot file format read by Load. A sled which is not a read-only
+// snapshot takes one first, so the file is always a point in time image and
+// writers are never paused. Keys which had expired at that point are left
+// out. Values are encoded by the Codec set by WithCodec, so their types must
+// be registered with RegisterType.
+func (s *sled) WriteTo(w io.Writer) (int64, error) {
+	if s.frozen == 0 {
+		return s.Snapshot(ReadOnly).WriteTo(w)
+	}
+	var count uint64
+	for range s.All() {
+		count++
+	}
+	sw := newSnapshotWriter(w, s.codec.Name(), count)
+	var value []byte
+	for k, v := range s.m.All() {
+		var at int64
+		if e, ok := v.(*wrapped); ok {
+			if e.deadline != 0 && e.deadline <= s.frozen {
+				continue
+			}
+			v, at = e.value, e.deadline
+		}
+		var err error
+		if value, err = EncodeValue(value[:0], s.codec, v); err != nil {
+			return sw.cw.n, fmt.Errorf("key %q: %w", k, err)
+		}
+		if err := sw.add(k, at, value); err != nil {
+			return sw.cw.n, err
+		}
+	}
+	return sw.close()
+}
+
+// snapshotWriter writes a snapshot file, a block of entries at a time.
+type snapshotWriter struct {
+	cw    *countWriter
+	bw    *bufio.Writer
+	block []byte
+	err   error
+}
+
+// newSnapshotWriter starts a snapshot file of count entries, with values
+// encoded by the codec called codec.
+func newSnapshotWriter(w io.Writer, codec string, count uint64) *snapshotWriter {
+	sw := &snapshotWriter{cw: &countWriter{w: w}, block: make([]byte, 0, snapshotBlock)}
+	sw.bw = bufio.NewWriter(sw.cw)
+	buf := append([]byte(snapshotMagic), 0, 0, byte(len(codec)))
+	binary.LittleEndian.PutUint16(buf[len(snapshotMagic):], snapshotVersion)
+	buf = append(buf, codec...)
+	buf = binary.LittleEndian.AppendUint64(buf, count)
+	_, sw.err = sw.bw.Write(buf)
+	return sw
+}
+
+// add appends an entry with an encoded value to the file.
+func (sw *snapshotWriter) add(key string, at int64, value []byte) error {
+	sw.block = binary.AppendUvarint(sw.block, uint64(len(key)))
+	sw.block = append(sw.block, key...)
+	sw.block = binary.AppendVarint(sw.block, at)
+	sw.block = binary.AppendUvarint(sw.block, uint64(len(value)))
+	sw.block = append(sw.block, value...)
+	if len(sw.block) >= snapshotBlock {
+		sw.flush()
+	}
+	return sw.err
+}
+
+// flush writes out the block of entries added since the last one. An empty
+// block ends the file, and has a zero checksum.
+func (sw *snapshotWriter) flush() {
+	if sw.err != nil {
+		return
+	}
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(sw.block)))
+	if len(sw.block) > 0 {
+		binary.LittleEndian.PutUint32(header[4:], crc32.Checksum(sw.block, crcTable))
+	}
+	sw.bw.Write(header[:])
+	_, sw.err = sw.bw.Write(sw.block)
+	sw.block = sw.block[:0]
+}
+
+// close ends the file, and returns the number of bytes written.
+func (sw *snapshotWriter) close() (int64, error) {
+	if len(sw.block) > 0 {
+		sw.flush()
+	}
+	sw.flush()
+	if sw.err == nil {
+		sw.err = sw.bw.Flush()
+	}
+	return sw.cw.n, sw.err
+}
+
+// Load returns a new sled configured by opts, holding the keys and values
+// read from a snapshot file written by WriteTo. Values are decoded by the
+// built-in codec the file names, or by the Codec set by WithCodec if it has
+// the name. Keys which have expired since the snapshot was taken are left
+// out. If the input is damaged Load returns an error wrapping
+// ErrInvalidSnapshot.
+func Load(r io.Reader, opts ...Option) (Sled, error) {
+	s := New(opts...).(*sled)
+	var pending []deadline
+	if err := s.readSnapshot(bufio.NewReader(r), &pending); err != nil {
+		s.Close()
+		return nil, err
+	}
+	for _, d := range pending {
+		s.expireAt(d.key, d.at)
+	}
+	return s, nil
+}
+
+func invalidSnapshot(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSnapshot, fmt.Sprintf(format, args...))
+}
+
+// readSnapshot reads a snapshot file into the sled, collecting the deadlines
+// of the keys which expire.
+func (s *sled) readSnapshot(r *bufio.Reader, pending *[]deadline) error {
+	header := make([]byte, len(snapshotMagic)+3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return invalidSnapshot("reading header: %v", err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return invalidSnapshot("bad magic")
+	}
+	version := binary.LittleEndian.Uint16(header[len(snapshotMagic):])
+	if version != 1 && version != snapshotVersion {
+		return invalidSnapshot("unsupported version %d", version)
+	}
+	header = make([]byte, int(header[len(header)-1])+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return invalidSnapshot("reading header: %v", err)
+	}
+	name := string(header[:len(header)-8])
+	codec, ok := codecNamed(name, s.codec)
+	if !ok {
+		return invalidSnapshot("unknown codec %q", name)
+	}
+	count := binary.LittleEndian.Uint64(header[len(header)-8:])
+	decode := func(value []byte) (interface{}, error) {
+		return DecodeValue(codec, value)
+	}
+	if version == 1 {
+		decode = decodeGobInterface
+	}
+
+	now := time.Now().UnixNano()
+	var (
+		entries uint64
+		block   bytes.Buffer
+	)
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return invalidSnapshot("reading block: %v", err)
+		}
+		length := binary.LittleEndian.Uint32(h[:])
+		if length == 0 {
+			break
+		}
+		// The block grows as it is read, so a damaged length cannot
+		// allocate more than the input holds.
+		block.Reset()
+		if _, err := io.CopyN(&block, r, int64(length)); err != nil {
+			return invalidSnapshot("reading block: %v", err)
+		}
+		p := block.Bytes()
+		if crc32.Checksum(p, crcTable) != binary.LittleEndian.Uint32(h[4:]) {
+			return invalidSnapshot("block checksum mismatch")
+		}
+		for len(p) > 0 {
+			key, at, value, n := readEntry(p)
+			if n <= 0 {
+				return invalidSnapshot("malformed entry")
+			}
+			p = p[n:]
+			entries++
+			if at != 0 && at <= now {
+				continue
+			}
+			v, err := decode(value)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+			s.restore(key, v, at, pending)
+		}
+	}
+	if entries != count {
+		return invalidSnapshot("%d entries, expected %d", entries, count)
+	}
+	return nil
+}
+
+// decodeGobInterface decodes a value of a version 1 snapshot file.
+func decodeGobInterface(value []byte) (interface{}, error) {
+	var v interface{}
+	err := gob.NewDecoder(bytes.NewReader(value)).Decode(&v)
+	return v, err
+}
+
+// restore sets key to a value read back from disk, which expires at the
+// deadline at unless it is 0. The deadline is collected in pending, to be
+// scheduled once the sled is ready.
+func (s *sled) restore(key string, v interface{}, at int64, pending *[]deadline) {
+	if at != 0 {
+		*pending = append(*pending, deadline{key, at})
+	}
+	v = s.wrap(key, v, at)
+	old, existed := s.m.Swap(key, v)
+	s.wrote(key, old, existed, v, true, nil)
+}
+
+// readEntry decodes the entry at the start of p, and returns its length, or
+// 0 if it is malformed.
+func readEntry(p []byte) (key string, deadline int64, value []byte, n int) {
+	k, m := binary.Uvarint(p)
+	if m <= 0 || uint64(len(p)-m) < k {
+		return "", 0, nil, 0
+	}
+	key, n = string(p[m:m+int(k)]), m+int(k)
+	if deadline, m = binary.Varint(p[n:]); m <= 0 {
+		return "", 0, nil, 0
+	}
+	n += m
+	l, m := binary.Uvarint(p[n:])
+	if m <= 0 || uint64(len(p)-n-m) < l {
+		return "", 0, nil, 0
+	}
+	n += m
+	return key, deadline, p[n : n+int(l)], n + int(l)
+}
@@ -0,0 +1,55 @@
+package sled
+
+import (
+	"iter"
+	"path"
+	"strings"
+)
+
+// ScanPrefix returns an iterator over the keys which begin with prefix, in
+// order, and their values. It seeks to the first such key in the sorted
+// index used by Ascend, so once the index is built the cost of a scan
+// depends on the number of keys it returns rather than on the size of the
+// sled.
+func (s *sled) ScanPrefix(prefix string) iter.Seq2[string, interface{}] {
+	return s.Ascend(prefix, prefixEnd(prefix))
+}
+
+// ScanGlob returns an iterator over the keys which match pattern, and their
+// values. Patterns have the syntax of path.Match, so a '*' does not match
+// '/'. Only keys beginning with the literal part of the pattern before its
+// first special character are matched, as by ScanPrefix. ScanGlob returns
+// path.ErrBadPattern if the pattern is malformed.
+func (s *sled) ScanGlob(pattern string) (iter.Seq2[string, interface{}], error) {
+	return scanGlob(pattern, s.ScanPrefix)
+}
+
+// scanGlob filters the keys under the literal prefix of pattern, as listed by
+// scanPrefix, by the pattern.
+func scanGlob(pattern string, scanPrefix func(prefix string) iter.Seq2[string, interface{}]) (iter.Seq2[string, interface{}], error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+	return func(yield func(string, interface{}) bool) {
+		for k, v := range scanPrefix(prefix) {
+			if ok, _ := path.Match(pattern, k); ok && !yield(k, v) {
+				return
+			}
+		}
+	}, nil
+}
+
+// prefixEnd returns the least key greater than every key beginning with
+// prefix, or the empty string if there is none.
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package sled
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// sipKey is the random per-process key of the default SipHash hasher.
+var sipKey = func() (k [2]uint64) {
+	var b [16]byte
+	rand.Read(b[:])
+	k[0] = binary.LittleEndian.Uint64(b[:8])
+	k[1] = binary.LittleEndian.Uint64(b[8:])
+	return k
+}()
+
+// sipHash is a keyed SipHash-2-4 Hash64. Because the key is secret, an
+// attacker cannot precompute keys whose hashes collide, which would otherwise
+// push them into a single L-node with linear lookups.
+type sipHash struct {
+	k0, k1         uint64
+	v0, v1, v2, v3 uint64
+	buf            [8]byte
+	nbuf           int
+	length         uint64
+}
+
+// NewSipHash returns a SipHash-2-4 Hash64 keyed with k0 and k1.
+func NewSipHash(k0, k1 uint64) hash.Hash64 {
+	h := &sipHash{k0: k0, k1: k1}
+	h.Reset()
+	return h
+}
+
+func (h *sipHash) Reset() {
+	h.v0 = h.k0 ^ 0x736f6d6570736575
+	h.v1 = h.k1 ^ 0x646f72616e646f6d
+	h.v2 = h.k0 ^ 0x6c7967656e657261
+	h.v3 = h.k1 ^ 0x7465646279746573
+	h.nbuf = 0
+	h.length = 0
+}
+
+func (h *sipHash) Size() int {
+	return 8
+}
+
+func (h *sipHash) BlockSize() int {
+	return 8
+}
+
+func (h *sipHash) Write(p []byte) (int, error) {
+	n := len(p)
+	h.length += uint64(n)
+	if h.nbuf > 0 {
+		c := copy(h.buf[h.nbuf:], p)
+		h.nbuf += c
+		p = p[c:]
+		if h.nbuf < 8 {
+			return n, nil
+		}
+		h.block(binary.LittleEndian.Uint64(h.buf[:]))
+		h.nbuf = 0
+	}
+	for ; len(p) >= 8; p = p[8:] {
+		h.block(binary.LittleEndian.Uint64(p))
+	}
+	h.nbuf = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *sipHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+// Sum64 finalizes a copy of the state, so writing may continue afterwards.
+func (h *sipHash) Sum64() uint64 {
+	d := *h
+	var last [8]byte
+	copy(last[:], d.buf[:d.nbuf])
+	m := binary.LittleEndian.Uint64(last[:]) | d.length<<56
+	d.block(m)
+	d.v2 ^= 0xff
+	d.round()
+	d.round()
+	d.round()
+	d.round()
+	return d.v0 ^ d.v1 ^ d.v2 ^ d.v3
+}
+
+// block compresses one 8 byte message word with two SipRounds.
+func (h *sipHash) block(m uint64) {
+	h.v3 ^= m
+	h.round()
+	h.round()
+	h.v0 ^= m
+}
+
+func (h *sipHash) round() {
+	h.v0, h.v1, h.v2, h.v3 = sipRound(h.v0, h.v1, h.v2, h.v3)
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
+
+// sipHashString computes SipHash-2-4 of s in one pass. Unlike the Hash64 it
+// needs neither a hasher nor a []byte copy of the key, so it does not
+// allocate.
+func sipHashString(k0, k1 uint64, s string) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	b := uint64(len(s)) << 56
+	for ; len(s) >= 8; s = s[8:] {
+		m := uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+			uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		b |= uint64(s[i]) << (8 * uint(i))
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= b
+	v2 ^= 0xff
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,130 @@
+package sled
+
+import (
+	"hash"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSipHashVectors(t *testing.T) {
+	assert := assert.New(t)
+	// Reference vectors from the SipHash paper, with key 00 01 .. 0f and
+	// message 00 01 .. (n-1).
+	expected := map[int]uint64{
+		0:  0x726fdb47dd0e0e31,
+		1:  0x74f839c593dc67fd,
+		2:  0x0d6c8009d9a94f5a,
+		3:  0x85676696d7fb7e2d,
+		15: 0xa129ca6149be45e5,
+	}
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := make([]byte, 64)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for n, want := range expected {
+		h := NewSipHash(k0, k1)
+		h.Write(msg[:n])
+		assert.Equal(want, h.Sum64(), "length %d", n)
+	}
+
+	// Chunked writes hash the same as a single write.
+	whole := NewSipHash(k0, k1)
+	whole.Write(msg)
+	chunked := NewSipHash(k0, k1)
+	for i := 0; i < len(msg); i += 3 {
+		end := i + 3
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunked.Write(msg[i:end])
+	}
+	assert.Equal(whole.Sum64(), chunked.Sum64())
+	assert.Equal(8, len(whole.Sum(nil)))
+
+	// The string variant agrees with the Hash64 at every length.
+	for n := 0; n <= len(msg); n++ {
+		h := NewSipHash(k0, k1)
+		h.Write(msg[:n])
+		assert.Equal(h.Sum64(), sipHashString(k0, k1, string(msg[:n])), "length %d", n)
+	}
+}
+
+// additiveHash64 is a weak unkeyed hash. Any permutation of a key collides.
+type additiveHash64 struct {
+	sum uint64
+}
+
+func (a *additiveHash64) Write(p []byte) (int, error) {
+	for _, b := range p {
+		a.sum += uint64(b)
+	}
+	return len(p), nil
+}
+func (a *additiveHash64) Sum(b []byte) []byte { return b }
+func (a *additiveHash64) Reset()              { a.sum = 0 }
+func (a *additiveHash64) Size() int           { return 8 }
+func (a *additiveHash64) BlockSize() int      { return 1 }
+func (a *additiveHash64) Sum64() uint64       { return a.sum }
+
+// maxLNodeLength returns the length of the longest L-node in the trie.
+func maxLNodeLength[K, V any](c *ctrie[K, V], i *iNode[K, V]) uint {
+	main := gcasRead(i, c)
+	max := uint(0)
+	switch {
+	case main.cNode != nil:
+		for _, br := range main.cNode.array {
+			if in, ok := br.(*iNode[K, V]); ok {
+				if l := maxLNodeLength(c, in); l > max {
+					max = l
+				}
+			}
+		}
+	case main.lNode != nil:
+		max = main.lNode.length()
+	}
+	return max
+}
+
+func TestSipHashCollisionResistance(t *testing.T) {
+	assert := assert.New(t)
+	// Craft keys which all collide under the weak hash: every permutation
+	// of the same multiset of digits.
+	var keys []string
+	var permute func(prefix string, rest string)
+	permute = func(prefix string, rest string) {
+		if rest == "" {
+			keys = append(keys, prefix)
+			return
+		}
+		for i := range rest {
+			permute(prefix+rest[i:i+1], rest[:i]+rest[i+1:])
+		}
+	}
+	permute("", "012345")
+	assert.Equal(720, len(keys))
+
+	// Under the weak hash every key lands in a single L-node.
+	weak := newCtrie[int](func() hash.Hash64 { return &additiveHash64{} })
+	// Under the default keyed SipHash the L-nodes stay bounded.
+	keyed := newCtrie[int](nil)
+	for i, k := range keys {
+		weak.Insert(k, i)
+		keyed.Insert(k, i)
+	}
+	assert.Equal(uint(720), maxLNodeLength(weak, weak.readRoot()))
+	assert.True(maxLNodeLength(keyed, keyed.readRoot()) <= 2)
+	for i, k := range keys {
+		v, ok := keyed.Lookup(k)
+		assert.True(ok)
+		assert.Equal(i, v)
+	}
+
+	// Different keys place the same input differently.
+	a, b := NewSipHash(1, 2), NewSipHash(3, 4)
+	a.Write([]byte(strconv.Itoa(42)))
+	b.Write([]byte(strconv.Itoa(42)))
+	assert.NotEqual(a.Sum64(), b.Sum64())
+}
@@ -0,0 +1,159 @@
+package sled
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// Sizer can be implemented by values to report the memory they hold, in
+// bytes. Values which are not Sizers are measured by walking them with
+// reflection.
+type Sizer interface {
+	SizeBytes() int64
+}
+
+// entrySize estimates the memory held by a key and its stored value. The
+// trie's own overhead for each entry is not counted.
+func entrySize(key string, v interface{}) int64 {
+	size := int64(len(key))
+	if e, ok := v.(*wrapped); ok {
+		size += int64(unsafe.Sizeof(*e))
+		v = e.value
+	}
+	return size + sizeOf(v)
+}
+
+// sizeOf estimates the memory held by a value: the size of the value itself
+// and of everything it refers to. Common types are measured directly.
+func sizeOf(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case Sizer:
+		return v.SizeBytes()
+	case string:
+		return int64(unsafe.Sizeof(v)) + int64(len(v))
+	case []byte:
+		return int64(unsafe.Sizeof(v)) + int64(cap(v))
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case int, uint, int64, uint64, float64, uintptr:
+		return 8
+	}
+	rv := reflect.ValueOf(v)
+	w := walker{seen: make(map[uintptr]bool)}
+	return int64(rv.Type().Size()) + w.indirect(rv)
+}
+
+var sizerType = reflect.TypeOf((*Sizer)(nil)).Elem()
+
+// flatTypes caches whether a type refers to no memory outside itself.
+var flatTypes sync.Map
+
+// flat reports whether values of type t refer to no memory outside
+// themselves, so their size is t.Size().
+func flat(t reflect.Type) bool {
+	if f, ok := flatTypes.Load(t); ok {
+		return f.(bool)
+	}
+	var f bool
+	switch {
+	case t.Implements(sizerType):
+		f = false
+	case t.Kind() == reflect.Array:
+		f = flat(t.Elem())
+	case t.Kind() == reflect.Struct:
+		f = true
+		for i := 0; i < t.NumField(); i++ {
+			if !flat(t.Field(i).Type) {
+				f = false
+				break
+			}
+		}
+	default:
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+			f = false
+		default:
+			// Numbers, and channels, functions and unsafe pointers,
+			// whose targets are not counted.
+			f = true
+		}
+	}
+	flatTypes.Store(t, f)
+	return f
+}
+
+// walker sums the memory values refer to. Memory reached through more than
+// one pointer is only counted once.
+type walker struct {
+	seen map[uintptr]bool
+}
+
+// visit reports whether the memory at p is being reached for the first time.
+func (w *walker) visit(p uintptr) bool {
+	if p == 0 || w.seen[p] {
+		return false
+	}
+	w.seen[p] = true
+	return true
+}
+
+// indirect returns the size of the memory v refers to, not counting v.
+func (w *walker) indirect(v reflect.Value) int64 {
+	t := v.Type()
+	if flat(t) {
+		return 0
+	}
+	if v.CanInterface() && t.Kind() != reflect.Interface && t.Implements(sizerType) {
+		if t.Kind() != reflect.Ptr || !v.IsNil() {
+			return v.Interface().(Sizer).SizeBytes() - int64(t.Size())
+		}
+	}
+	var size int64
+	switch t.Kind() {
+	case reflect.String:
+		size = int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || !w.visit(v.Pointer()) {
+			return 0
+		}
+		size = int64(v.Cap()) * int64(t.Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += w.indirect(v.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			size += w.indirect(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			size += w.indirect(v.Field(i))
+		}
+	case reflect.Map:
+		if v.IsNil() || !w.visit(v.Pointer()) {
+			return 0
+		}
+		entry := int64(t.Key().Size() + t.Elem().Size())
+		for it := v.MapRange(); it.Next(); {
+			size += entry + w.indirect(it.Key()) + w.indirect(it.Value())
+		}
+	case reflect.Ptr:
+		if v.IsNil() || !w.visit(v.Pointer()) {
+			return 0
+		}
+		size = int64(t.Elem().Size()) + w.indirect(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		size = int64(e.Type().Size()) + w.indirect(e)
+	}
+	return size
+}
@@ -0,0 +1,51 @@
+package sled
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedSize struct{}
+
+func (fixedSize) SizeBytes() int64 { return 1000 }
+
+type linked struct {
+	Name string
+	Next *linked
+}
+
+func TestSizeOf(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(int64(0), sizeOf(nil))
+	assert.Equal(int64(8), sizeOf(42))
+	assert.Equal(int64(16+5), sizeOf("hello"))
+	assert.Equal(int64(24+10), sizeOf(make([]byte, 5, 10)))
+	assert.Equal(int64(1000), sizeOf(fixedSize{}))
+
+	// A slice of flat elements is not walked.
+	assert.Equal(int64(24+8*4), sizeOf([]int64{1, 2, 3, 4}))
+	// Strings in a slice are.
+	assert.Equal(int64(24+2*16+3+4), sizeOf([]string{"abc", "defg"}))
+
+	type record struct {
+		ID    int64
+		Name  string
+		Tags  map[string]int32
+		Extra interface{}
+		Sized fixedSize
+	}
+	r := record{ID: 1, Name: "ab", Tags: map[string]int32{"x": 1}, Extra: "cd"}
+	// A trailing zero size field pads the struct.
+	size := int64(unsafe.Sizeof(r)) + 2 + (16 + 4 + 1) + (16 + 2) + 1000
+	assert.Equal(size, sizeOf(r))
+	assert.Equal(8+size, sizeOf(&r))
+
+	// Memory reached twice, or through a cycle, is counted once.
+	a := &linked{Name: "a"}
+	b := &linked{Name: "b", Next: a}
+	a.Next = b
+	assert.Equal(int64(8+2*(16+8+1)), sizeOf(a))
+	assert.Equal(int64(24+2*8+2*(16+8+1)), sizeOf([]*linked{b, b}))
+}
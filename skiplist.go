@@ -0,0 +1,219 @@
+package sled
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+	"unsafe"
+)
+
+// slMaxLevel bounds the height of skiplist towers. With a branching factor
+// of 4 it comfortably covers billions of keys.
+const slMaxLevel = 16
+
+// skiplist is a lock-free ordered set of string keys, after the lock-free
+// skiplist of Herlihy and Shavit. A node is removed by first marking the
+// links out of it, from the top of its tower down, which logically deletes
+// it once the bottom link is marked. Searches then unlink marked nodes as
+// they pass them.
+type skiplist struct {
+	head *slNode
+}
+
+// slNode is a key and the tower of links to the nodes after it.
+type slNode struct {
+	key  string
+	next []*slLink
+}
+
+// slLink is an immutable reference to the next node at some level, together
+// with the deletion mark of the node it leaves from. A link and its mark are
+// swapped as one with a CAS on the pointer.
+type slLink struct {
+	node   *slNode
+	marked bool
+}
+
+func newSkiplist() *skiplist {
+	head := &slNode{next: make([]*slLink, slMaxLevel)}
+	for l := range head.next {
+		head.next[l] = &slLink{}
+	}
+	return &skiplist{head: head}
+}
+
+func (n *slNode) link(l int) *slLink {
+	return (*slLink)(atomic.LoadPointer(upp(unsafe.Pointer(&n.next[l]))))
+}
+
+func (n *slNode) casLink(l int, ov, nv *slLink) bool {
+	return atomic.CompareAndSwapPointer(upp(unsafe.Pointer(&n.next[l])), unsafe.Pointer(ov), unsafe.Pointer(nv))
+}
+
+// randomLevel picks a tower height, each level being a quarter as likely as
+// the one below it.
+func randomLevel() int {
+	level := 1 + bits.TrailingZeros64(rand.Uint64()|1<<62)/2
+	if level > slMaxLevel {
+		level = slMaxLevel
+	}
+	return level
+}
+
+// find fills preds with the last node before key at each level, and links
+// with the unmarked link out of it which was observed, unlinking marked
+// nodes on the way. It reports whether key is in the set.
+func (s *skiplist) find(key string, preds *[slMaxLevel]*slNode, links *[slMaxLevel]*slLink) bool {
+retry:
+	for {
+		pred := s.head
+		for l := slMaxLevel - 1; l >= 0; l-- {
+			pl := pred.link(l)
+			for {
+				if pl.marked {
+					continue retry
+				}
+				curr := pl.node
+				if curr == nil {
+					break
+				}
+				cl := curr.link(l)
+				if cl.marked {
+					nl := &slLink{node: cl.node}
+					if !pred.casLink(l, pl, nl) {
+						continue retry
+					}
+					pl = nl
+					continue
+				}
+				if curr.key >= key {
+					break
+				}
+				pred, pl = curr, cl
+			}
+			preds[l], links[l] = pred, pl
+		}
+		return links[0].node != nil && links[0].node.key == key
+	}
+}
+
+// insert adds key to the set, returning false if it was already present.
+func (s *skiplist) insert(key string) bool {
+	var (
+		preds [slMaxLevel]*slNode
+		links [slMaxLevel]*slLink
+	)
+	level := randomLevel()
+	for {
+		if s.find(key, &preds, &links) {
+			return false
+		}
+		n := &slNode{key: key, next: make([]*slLink, level)}
+		for l := range n.next {
+			n.next[l] = &slLink{node: links[l].node}
+		}
+		// The node is in the set once it is linked in at the bottom level.
+		if !preds[0].casLink(0, links[0], &slLink{node: n}) {
+			continue
+		}
+		for l := 1; l < level; l++ {
+			for {
+				nl := n.link(l)
+				if nl.marked {
+					// Removed while the tower was being built.
+					return true
+				}
+				if nl.node != links[l].node && !n.casLink(l, nl, &slLink{node: links[l].node}) {
+					continue
+				}
+				if preds[l].casLink(l, links[l], &slLink{node: n}) {
+					break
+				}
+				s.find(key, &preds, &links)
+			}
+		}
+		return true
+	}
+}
+
+// remove deletes key from the set, returning false if it was not present.
+func (s *skiplist) remove(key string) bool {
+	var (
+		preds [slMaxLevel]*slNode
+		links [slMaxLevel]*slLink
+	)
+	if !s.find(key, &preds, &links) {
+		return false
+	}
+	victim := links[0].node
+	for l := len(victim.next) - 1; l > 0; l-- {
+		for {
+			vl := victim.link(l)
+			if vl.marked || victim.casLink(l, vl, &slLink{node: vl.node, marked: true}) {
+				break
+			}
+		}
+	}
+	for {
+		vl := victim.link(0)
+		if vl.marked {
+			// Another remove got there first.
+			return false
+		}
+		if victim.casLink(0, vl, &slLink{node: vl.node, marked: true}) {
+			s.find(key, &preds, &links)
+			return true
+		}
+	}
+}
+
+// contains reports whether key is in the set.
+func (s *skiplist) contains(key string) bool {
+	var (
+		preds [slMaxLevel]*slNode
+		links [slMaxLevel]*slLink
+	)
+	return s.find(key, &preds, &links)
+}
+
+// ceiling returns the first node whose key is not less than key, or nil.
+func (s *skiplist) ceiling(key string) *slNode {
+	var (
+		preds [slMaxLevel]*slNode
+		links [slMaxLevel]*slLink
+	)
+	s.find(key, &preds, &links)
+	return links[0].node
+}
+
+// lower returns the last node whose key is less than key, or nil. If top is
+// true key is ignored and the last node in the set is returned.
+func (s *skiplist) lower(key string, top bool) *slNode {
+	pred := s.head
+	for l := slMaxLevel - 1; l >= 0; l-- {
+		for {
+			curr := pred.link(l).node
+			if curr == nil || !top && curr.key >= key {
+				break
+			}
+			pred = curr
+		}
+	}
+	// Step back over a node that was deleted after it was passed.
+	if pred != s.head && pred.link(0).marked {
+		return s.lower(pred.key, false)
+	}
+	if pred == s.head {
+		return nil
+	}
+	return pred
+}
+
+// successor returns the first node after n which is not deleted, or nil.
+func (n *slNode) successor() *slNode {
+	next := n.link(0).node
+	for next != nil && next.link(0).marked {
+		next = next.link(0).node
+	}
+	return next
+}
@@ -0,0 +1,158 @@
+package sled
+
+import (
+	"iter"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func skiplistKeys(s *skiplist) []string {
+	var keys []string
+	for n := s.head.successor(); n != nil; n = n.successor() {
+		keys = append(keys, n.key)
+	}
+	return keys
+}
+
+func TestSkiplist(t *testing.T) {
+	assert := assert.New(t)
+	s := newSkiplist()
+	assert.Nil(s.ceiling(""))
+	assert.Nil(s.lower("", true))
+
+	var want []string
+	for i := 0; i < 1000; i += 2 {
+		assert.True(s.insert(strconv.Itoa(i)))
+		want = append(want, strconv.Itoa(i))
+	}
+	assert.False(s.insert("10"))
+	sort.Strings(want)
+	assert.Equal(want, skiplistKeys(s))
+
+	assert.True(s.contains("10"))
+	assert.False(s.contains("11"))
+	assert.Equal("10", s.ceiling("10").key)
+	assert.Equal("10", s.ceiling("1").key)
+	assert.Equal("110", s.ceiling("11").key)
+	assert.Equal("0", s.lower("1", false).key)
+	assert.Equal("998", s.lower("", true).key)
+	assert.Nil(s.lower("0", false))
+
+	assert.True(s.remove("10"))
+	assert.False(s.remove("10"))
+	assert.False(s.contains("10"))
+	assert.Equal("100", s.ceiling("10").key)
+	assert.Equal(len(want)-1, len(skiplistKeys(s)))
+}
+
+func TestSkiplistConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	s := newSkiplist()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := strconv.Itoa(i)
+				s.insert(k)
+				// Each goroutine removes its own share of the odd keys.
+				if i%2 == 1 && i%8 == g {
+					s.insert(k)
+					s.remove(k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	keys := skiplistKeys(s)
+	assert.True(sort.StringsAreSorted(keys))
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		assert.False(seen[k])
+		seen[k] = true
+	}
+	for i := 0; i < 1000; i += 2 {
+		assert.True(s.contains(strconv.Itoa(i)))
+	}
+}
+
+func TestOrderedIndex(t *testing.T) {
+	assert := assert.New(t)
+	s := New().(*sled)
+	s.Set("a", 1)
+	s.Set("b", 2)
+	assert.Nil(s.idx.Load())
+	snap := s.Snapshot(ReadWrite).(*sled)
+	assert.Nil(snap.idx.Load())
+
+	// The first scan builds the index, which later writes keep up to date.
+	k, _, _ := s.Max()
+	assert.Equal("b", k)
+	s.Set("c", 3)
+	s.Delete("a")
+	assert.Equal([]string{"b", "c"}, skiplistKeys(s.idx.Load().skiplist))
+
+	// Keys left behind by a racing remove are dropped by the next scan.
+	s.idx.Load().insert("stale")
+	s.idx.Load().insert("0")
+	var keys []string
+	for k := range s.Ascend("", "") {
+		keys = append(keys, k)
+	}
+	assert.Equal([]string{"b", "c"}, keys)
+	assert.Equal([]string{"b", "c"}, skiplistKeys(s.idx.Load().skiplist))
+	s.idx.Load().insert("stale")
+	k, _, _ = s.Max()
+	assert.Equal("c", k)
+	assert.False(s.idx.Load().contains("stale"))
+
+	k, _, _ = snap.Min()
+	assert.Equal("a", k)
+}
+
+func TestSnapshotIndex(t *testing.T) {
+	assert := assert.New(t)
+	s := New(WithOrdered()).(*sled)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.Set(k, k)
+	}
+	ro := s.Snapshot(ReadOnly).(*sled)
+	s.Delete("b")
+	s.Delete("d")
+	s.Set("bb", "bb")
+	s.Delete("bb")
+	s.Set("b", "b")
+
+	// The snapshot scans the index of its source, finding the keys removed
+	// since in its log, and never sorts its own.
+	collect := func(seq iter.Seq2[string, interface{}]) []string {
+		var keys []string
+		for k := range seq {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	assert.Same(s.idx.Load(), ro.view.idx)
+	assert.Equal([]string{"a", "b", "c", "d"}, collect(ro.Ascend("", "")))
+	assert.Equal([]string{"b", "c"}, collect(ro.Ascend("aa", "d")))
+	assert.Equal([]string{"d", "c", "b", "a"}, collect(ro.Descend("", "")))
+	assert.Equal([]string{"c", "b"}, collect(ro.Descend("d", "b")))
+	assert.Equal(ro.view, ro.Snapshot(ReadOnly).(*sled).view)
+	assert.Nil(ro.view.sorted.keys)
+
+	// BytesByPrefix builds the index once, rather than sorting a snapshot
+	// on every call.
+	s = New().(*sled)
+	s.Set("a", 1)
+	assert.Equal(entrySize("a", 1), s.BytesByPrefix("a")["a"])
+	idx := s.idx.Load()
+	assert.NotNil(idx)
+	s.BytesByPrefix("a")
+	assert.Same(idx, s.idx.Load())
+}
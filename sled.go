@@ -1,19 +1,82 @@
 package sled
 
 import (
+	"context"
 	"errors"
+	"iter"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
-// Create a new Sled object.
-func New() Sled {
-	ct := newCtrie(nil)
-	return &sled{ct}
+// Create a new Sled object configured by the given options.
+func New(opts ...Option) Sled {
+	cfg := newConfig(opts)
+	s := &sled{m: NewMap[interface{}](opts...), codec: cfg.codec}
+	if s.codec == nil {
+		s.codec = GobCodec{}
+	}
+	if cfg.ordered {
+		idx := newIndex()
+		idx.filled.Store(true)
+		s.idx.Store(idx)
+	}
+	s.sized = cfg.maxBytes > 0
+	if cfg.maxEntries > 0 || cfg.maxBytes > 0 {
+		s.limits = &limits{
+			maxEntries: cfg.maxEntries,
+			maxBytes:   cfg.maxBytes,
+			policy:     cfg.policy,
+			onEvict:    cfg.onEvict,
+		}
+		if s.limits.policy == nil {
+			s.limits.policy = NewLRU()
+		}
+	}
+	return s
 }
 
+// sled adapts a Map of interface{} values to the Sled interface.
 type sled struct {
-	ct *ctrie
+	m *Map[interface{}]
+
+	// idx is the ordered index of the keys. A sled created WithOrdered
+	// keeps it from the start, others build it on their first ordered scan.
+	idx     atomic.Pointer[index]
+	idxOnce sync.Once
+
+	// view is how a read-only snapshot scans its keys in order.
+	view *view
+
+	// frozen is the time at which a read-only snapshot was taken, which
+	// the deadlines of its keys are checked against.
+	frozen int64
+
+	// sweep removes expired keys. It is started by the first write with a
+	// time to live, or by the first write to a read-write snapshot which
+	// inherited deadlines from its source. inherited lists those.
+	sweep     *sweeper
+	sweepOnce sync.Once
+	expires   int32
+	inherited func() []deadline
+
+	// limits bound a sled created WithMaxEntries or WithMaxBytes. Nothing
+	// is evicted while a durable sled replays its log, which holds the
+	// evictions made before.
+	limits    *limits
+	replaying bool
+
+	// sized is set for a sled created WithMaxBytes, which wraps every value
+	// with the size of its entry, measured once when it is written, and
+	// keeps their total in bytes.
+	sized bool
+	bytes int64
+
+	// wal logs the writes to a durable sled created by Open.
+	wal *wal
+
+	// codec encodes the values written to the log or a snapshot file.
+	codec Codec
 }
 
 type ele struct {
@@ -36,32 +99,381 @@ func (e *ele) Value() interface{} {
 	return e.v
 }
 
+// wrote is called after every write to key, with the values stored for it
+// before and after the write, and the encoding of the new value if encode
+// made one. It keeps the indexes of the sled in line with the trie, logs the
+// write if the sled is durable, and evicts keys while the sled is over its
+// limits.
+func (s *sled) wrote(key string, old interface{}, hadOld bool, new interface{}, hasNew bool, data []byte) {
+	if s.wal != nil && (hadOld || hasNew) {
+		s.wal.append(key, new, hasNew, data)
+	}
+	if s.sized {
+		var delta int64
+		if hadOld {
+			delta -= old.(*wrapped).size
+		}
+		if hasNew {
+			delta += new.(*wrapped).size
+		}
+		if delta != 0 {
+			atomic.AddInt64(&s.bytes, delta)
+		}
+	}
+	s.track(key)
+	if s.inherited != nil {
+		s.sweepOnce.Do(s.startSweep)
+	}
+	if hasNew && s.limits != nil && !s.replaying {
+		s.evict()
+	}
+}
+
+// track brings the ordered index and the eviction policy in line with the
+// trie after a write to key. A key that was removed is checked for again
+// after it leaves them, so that a concurrent write which stored it is not
+// lost. A key can linger in them after a racing remove, until a scan drops
+// it from the index or an eviction from the policy.
+func (s *sled) track(key string) {
+	idx := s.idx.Load()
+	if idx == nil && s.limits == nil {
+		return
+	}
+	if _, ok := s.m.Load(key); ok {
+		s.index(key)
+		return
+	}
+	if idx != nil {
+		idx.drop(key)
+	}
+	if s.limits != nil {
+		s.limits.policy.Remove(key)
+	}
+	if _, ok := s.m.Load(key); ok {
+		s.index(key)
+	}
+}
+
+func (s *sled) index(key string) {
+	if idx := s.idx.Load(); idx != nil {
+		idx.insert(key)
+	}
+	if s.limits != nil {
+		s.limits.policy.Add(key)
+	}
+}
+
+// Size returns the number of keys in the sled.
 func (s *sled) Size() uint {
-	return s.ct.Size()
+	return s.m.Size()
 }
 
-// Assigns value to key, replacing any previous values.
+// Len returns the number of keys in the sled as counted by writes. It is
+// approximate while writes are in flight.
+func (s *sled) Len() uint {
+	return s.m.Len()
+}
+
+// Bytes returns the estimated memory held by the keys and values of the
+// sled. Values are measured by their Sizer method if they have one, and
+// otherwise by walking them with reflection. A sled created WithMaxBytes
+// measures each entry once when it is written, and keeps a total which,
+// like Len, is only approximate while writes are in flight. Other sleds
+// measure a point in time image of all their entries on every call.
+func (s *sled) Bytes() int64 {
+	if s.sized {
+		return atomic.LoadInt64(&s.bytes)
+	}
+	var size int64
+	for k, v := range s.m.Snapshot(ReadOnly).All() {
+		size += entrySize(k, v)
+	}
+	return size
+}
+
+// BytesByPrefix estimates the memory held by the keys under each prefix and
+// their values, in a point in time image of the sled. Keys under several of
+// the prefixes are counted for each. The image scans the ordered index of
+// the sled, which the first call builds if the sled has none yet.
+func (s *sled) BytesByPrefix(prefixes ...string) map[string]int64 {
+	if s.view == nil {
+		s.ordered()
+	}
+	snap := s.Snapshot(ReadOnly).(*sled)
+	sizes := make(map[string]int64, len(prefixes))
+	for _, prefix := range prefixes {
+		var size int64
+		for k := range snap.ScanPrefix(prefix) {
+			v, _ := snap.m.Load(k)
+			size += snap.entrySize(k, v)
+		}
+		sizes[prefix] = size
+	}
+	return sizes
+}
+
+// entrySize returns the size of the entry of key, which stores v.
+func (s *sled) entrySize(key string, v interface{}) int64 {
+	if s.sized {
+		return v.(*wrapped).size
+	}
+	return entrySize(key, v)
+}
+
+// Assigns value to key, replacing any previous values. Set returns
+// ErrReadOnly on a read-only snapshot.
 func (s *sled) Set(key string, value interface{}) error {
-	s.ct.Insert([]byte(key), value)
-	return nil
+	if err := s.m.ct.writable(); err != nil {
+		return err
+	}
+	defer s.lock()()
+	data, err := s.encode(value)
+	if err != nil {
+		return err
+	}
+	v := s.wrap(key, value, 0)
+	old, existed := s.m.Swap(key, v)
+	s.wrote(key, old, existed, v, true, data)
+	return s.logErr()
 }
 
 // SetNil is exclusive Set.  It only assigns the value to the key,
 // if the key is not already set.  It returns true if the assignment succeed.
+// When called concurrently for the same key, exactly one caller succeeds.
+// On a read-only snapshot it returns false.
 func (s *sled) SetIfNil(key string, value interface{}) bool {
-	if _, existed := s.ct.Lookup([]byte(key)); !existed {
-		s.Set(key, value)
-		return true
+	set, _ := s.TrySetIfNil(key, value)
+	return set
+}
+
+// TrySetIfNil is SetIfNil which returns ErrReadOnly on a read-only snapshot,
+// and the error which stopped the log of a durable sled.
+func (s *sled) TrySetIfNil(key string, value interface{}) (bool, error) {
+	if err := s.m.ct.writable(); err != nil {
+		return false, err
+	}
+	defer s.lock()()
+	_, loaded, err := s.getOrSet(key, value)
+	if err != nil {
+		return false, err
+	}
+	return !loaded, s.logErr()
+}
+
+// GetOrSet returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored. A read-only snapshot stores nothing, and
+// returns nil and false if the key is not set, as does a durable sled if the
+// value cannot be logged.
+func (s *sled) GetOrSet(key string, value interface{}) (actual interface{}, loaded bool) {
+	if s.m.ct.readOnly {
+		if v, ok := s.m.Load(key); ok {
+			return s.live(v)
+		}
+		return nil, false
+	}
+	defer s.lock()()
+	actual, loaded, err := s.getOrSet(key, value)
+	if err != nil {
+		return nil, false
 	}
-	return false
+	return actual, loaded
+}
+
+// getOrSet stores value for key if the key is not set, or has expired. The
+// caller holds the lock.
+func (s *sled) getOrSet(key string, value interface{}) (interface{}, bool, error) {
+	data, err := s.encode(value)
+	if err != nil {
+		// The value could only be loaded.
+		if v, ok := s.load(key); ok {
+			return v, true, nil
+		}
+		return nil, false, err
+	}
+	now := s.clock()
+	absent := func(v interface{}, exists bool) bool {
+		if exists {
+			_, exists = liveAt(v, now)
+		}
+		return !exists
+	}
+	v := s.wrap(key, value, 0)
+	prev, existed := s.m.ct.InsertIf(key, v, absent)
+	if absent(prev, existed) {
+		s.wrote(key, prev, existed, v, true, data)
+		return value, false, nil
+	}
+	prev, _ = liveAt(prev, now)
+	return prev, true, nil
+}
+
+// Update atomically replaces the value of key with the result of fn. fn is
+// called with the value current at the moment of the update, and is retried
+// if a concurrent write intervenes, so it may run more than once and should
+// not have side effects. If fn returns keep == false the key is deleted. A
+// key keeps its time to live across an Update. Update returns ErrReadOnly on
+// a read-only snapshot.
+func (s *sled) Update(key string, fn UpdateFunc) error {
+	if err := s.m.ct.writable(); err != nil {
+		return err
+	}
+	defer s.lock()()
+	now := s.clock()
+	err := s.update(key, func(old interface{}, exists bool) (interface{}, bool) {
+		var deadline int64
+		if e, ok := old.(*wrapped); ok && exists {
+			if old, exists = liveAt(old, now); exists {
+				deadline = e.deadline
+			}
+		}
+		v, keep := fn(old, exists)
+		if keep {
+			v = s.wrap(key, v, deadline)
+		}
+		return v, keep
+	})
+	if err != nil {
+		return err
+	}
+	return s.logErr()
+}
+
+// update is HashMap.Update which reports the write it made to wrote. If the
+// new value cannot be logged the key is left as it was, and the error is
+// returned.
+func (s *sled) update(key string, fn UpdateFunc) error {
+	var (
+		old, new       interface{}
+		hadOld, hasNew bool
+		data           []byte
+		err            error
+	)
+	s.m.Update(key, func(v interface{}, exists bool) (interface{}, bool) {
+		old, hadOld = v, exists
+		new, hasNew = fn(v, exists)
+		if data, err = nil, nil; hasNew {
+			data, err = s.encode(new)
+		}
+		if err != nil {
+			return v, exists
+		}
+		return new, hasNew
+	})
+	if err != nil {
+		return err
+	}
+	s.wrote(key, old, hadOld, new, hasNew, data)
+	return nil
+}
+
+// CompareAndSwap assigns the new value to key only if the value currently
+// stored is equal to old. The comparison and the assignment happen
+// atomically. It returns true if the swap took place. A key keeps its time
+// to live across a swap. On a read-only snapshot it returns false.
+func (s *sled) CompareAndSwap(key string, old, new interface{}) bool {
+	if s.m.ct.readOnly {
+		return false
+	}
+	defer s.lock()()
+	data, err := s.encode(new)
+	if err != nil {
+		return false
+	}
+	now := s.clock()
+	for {
+		// The flags are set by the last call of the condition, which
+		// decided whether the value was replaced, so an Equaler is not
+		// asked again after the fact.
+		var (
+			w       *wrapped
+			swapped bool
+		)
+		prev, _ := s.m.ct.InsertIf(key, new, func(v interface{}, exists bool) bool {
+			w, _ = v.(*wrapped)
+			swapped = exists && w == nil && valuesEqual(old, v)
+			return swapped
+		})
+		if swapped {
+			s.wrote(key, prev, true, new, true, data)
+			return true
+		}
+		if w == nil {
+			return false
+		}
+		// The new value is wrapped as the stored one was, keeping the
+		// time to live of the key. It replaces the value it was compared
+		// with only if that is still stored, so a failed compare writes
+		// nothing.
+		if cur, live := liveAt(w, now); !live || !valuesEqual(old, cur) {
+			return false
+		}
+		next := s.wrap(key, new, w.deadline)
+		s.m.ct.InsertIf(key, next, func(v interface{}, exists bool) bool {
+			e, _ := v.(*wrapped)
+			swapped = exists && e == w
+			return swapped
+		})
+		if swapped {
+			s.wrote(key, w, true, next, true, data)
+			return true
+		}
+	}
+}
+
+// CompareAndDelete removes key only if the value currently stored is equal
+// to old. The comparison and the removal happen atomically. It returns true
+// if the key was deleted. On a read-only snapshot it returns false.
+func (s *sled) CompareAndDelete(key string, old interface{}) bool {
+	if s.m.ct.readOnly {
+		return false
+	}
+	defer s.lock()()
+	now := s.clock()
+	var deleted bool
+	prev, _ := s.m.ct.RemoveIf(key, func(v interface{}, exists bool) bool {
+		v, live := liveAt(v, now)
+		deleted = exists && live && valuesEqual(old, v)
+		return deleted
+	})
+	if !deleted {
+		return false
+	}
+	s.wrote(key, prev, true, nil, false, nil)
+	return true
+}
+
+// valuesEqual reports whether the stored value v is equal to the expected
+// value. If expected implements Equaler its Equal method decides, otherwise
+// values are compared with ==. Values that are not comparable are never equal.
+func valuesEqual(expected, v interface{}) bool {
+	if eq, ok := expected.(Equaler); ok {
+		return eq.Equal(v)
+	}
+	if expected == nil || v == nil {
+		return expected == v
+	}
+	ev, vv := reflect.ValueOf(expected), reflect.ValueOf(v)
+	if ev.Type() != vv.Type() || !ev.Comparable() || !vv.Comparable() {
+		return false
+	}
+	return expected == v
 }
 
 // Get return the value stored for the given key, or nil if no value was found.
 func (s *sled) Get(key string, v interface{}) error {
-	val, ok := s.ct.Lookup([]byte(key))
+	val, ok := s.load(key)
 	if !ok {
 		return errors.New("key does not exist")
 	}
+	if s.limits != nil {
+		s.limits.reads.record(s.limits.policy, key)
+	}
+	return assign(v, val)
+}
+
+// assign stores val in the value v points to, which must have the same type.
+func assign(v, val interface{}) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr {
 		return errors.New("argument must be a pointer")
@@ -76,21 +488,154 @@ func (s *sled) Get(key string, v interface{}) error {
 }
 
 // Delete removes a key and value, and returns it's previous value with
-// an existed flag that will be true if the key was not empty.
+// an existed flag that will be true if the key was not empty. On a read-only
+// snapshot it deletes nothing, and returns nil and false.
 func (s *sled) Delete(key string) (value interface{}, existed bool) {
-	value, existed = s.ct.Remove([]byte(key))
-	return
+	value, existed, _ = s.TryDelete(key)
+	return value, existed
 }
 
-// Close releases all sled resources.
+// TryDelete is Delete which returns ErrReadOnly on a read-only snapshot, and
+// the error which stopped the log of a durable sled.
+func (s *sled) TryDelete(key string) (value interface{}, existed bool, err error) {
+	if err := s.m.ct.writable(); err != nil {
+		return nil, false, err
+	}
+	defer s.lock()()
+	value, existed = s.delete(key)
+	return value, existed, s.logErr()
+}
+
+func (s *sled) delete(key string) (value interface{}, existed bool) {
+	value, existed = s.m.Delete(key)
+	if existed {
+		s.wrote(key, value, true, nil, false, nil)
+		value, existed = s.live(value)
+		if !existed {
+			value = nil
+		}
+	}
+	return value, existed
+}
+
+// Close releases all sled resources, stopping the background removal of
+// expired keys. A sled, or a read-write snapshot, with keys waiting to expire
+// is otherwise kept alive by the goroutine which removes them until the last
+// of them has expired, so one which is dropped early should be closed first.
+// Closing a durable sled stops its background checkpoints, and flushes and
+// closes its log, returning the first error met writing it or the error of
+// the last background checkpoint. Later writes are not logged.
 func (s *sled) Close() error {
+	s.sweepOnce.Do(func() {})
+	if s.sweep != nil {
+		s.sweep.close()
+	}
+	if s.wal != nil {
+		cerr := s.wal.checkpoint.stop()
+		if err := s.wal.close(); err != nil {
+			return err
+		}
+		return cerr
+	}
 	return nil
 }
 
 // Snapshot returns a single point in time image of the Sled.
-// Snapshot is fast and non blocking.
+// Snapshot is fast and non blocking. A read-write snapshot builds its own
+// ordered index on its first ordered scan, and copies the deadlines of the
+// keys of its source which have a time to live, to be scheduled by its
+// first write. A read-write snapshot is not bound by the limits of its
+// source.
+//
+// Writes to a read-only snapshot do not panic: those which return an error
+// return ErrReadOnly, and the others report that nothing was written.
+// TryDelete and TrySetIfNil return ErrReadOnly where Delete and SetIfNil
+// return false. A read-write snapshot of a read-only one is writable.
 func (s *sled) Snapshot(mode IoMode) Sled {
-	return &sled{s.ct.Snapshot(mode)}
+	if mode == ReadOnly {
+		now := s.clock()
+		v := s.newView()
+		return &sled{
+			m:      s.m.Snapshot(ReadOnly),
+			view:   v,
+			frozen: now,
+			sized:  s.sized,
+			bytes:  atomic.LoadInt64(&s.bytes),
+			codec:  s.codec,
+		}
+	}
+	snap := &sled{sized: s.sized, bytes: atomic.LoadInt64(&s.bytes), codec: s.codec}
+	snap.m, snap.inherited = s.inherit()
+	return snap
+}
+
+// Iterator returns a cursor over a point in time image of the sled.
+//
+//	it := sl.Iterator()
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+func (s *sled) Iterator() Iterator {
+	return &liveIter{Iterator: s.m.Iterator(), now: s.clock()}
+}
+
+// IterateContext returns a cursor over a point in time image of the sled,
+// which stops once ctx is done. Err then reports an ErrCanceled wrapping
+// ctx.Err().
+func (s *sled) IterateContext(ctx context.Context) Iterator {
+	return &liveIter{Iterator: s.m.IteratorContext(ctx), now: s.clock()}
+}
+
+// IterateFrom returns a cursor over the next limit entries of the sled after
+// the position token, and the Token which resumes after them. Pages taken
+// from the same read-only snapshot cover every key exactly once. See
+// HashMap.IterateFrom. A page holds fewer than limit entries if some of its
+// keys have expired.
+func (s *sled) IterateFrom(token Token, limit int) (Iterator, Token, error) {
+	now := s.clock()
+	it, next, err := s.m.IterateFrom(token, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	return &liveIter{Iterator: it, now: now}, next, nil
+}
+
+// Range calls fn for each key and value in a point in time image of the
+// sled, until fn returns false or ctx is done. If ctx stops the iteration
+// Range returns an ErrCanceled wrapping ctx.Err(), so errors.Is reports
+// whether the scan was canceled or ran out of time.
+func (s *sled) Range(ctx context.Context, fn func(key string, value interface{}) bool) error {
+	return s.m.RangeContext(ctx, liveFunc(s.clock(), fn))
+}
+
+// ParallelRange calls fn for each key and value in a point in time image of
+// the sled, from up to workers goroutines at once. fn must be safe for
+// concurrent use. See HashMap.ParallelRange.
+func (s *sled) ParallelRange(ctx context.Context, workers int, fn func(key string, value interface{}) bool) error {
+	return s.m.ParallelRange(ctx, workers, liveFunc(s.clock(), fn))
+}
+
+// All returns an iterator over the keys and values of a point in time image
+// of the sled, for use with range. Breaking out of the loop stops the
+// traversal; there is nothing to close or cancel.
+//
+//	for k, v := range sl.All() {
+//		fmt.Println(k, v)
+//	}
+func (s *sled) All() iter.Seq2[string, interface{}] {
+	return liveSeq(s.clock(), s.m.All())
+}
+
+// Keys returns an iterator over the keys of a point in time image of the
+// sled, for use with range.
+func (s *sled) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for k := range s.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
 }
 
 var elePool = sync.Pool{
@@ -108,10 +653,15 @@ func (s *sled) Iterate(cancel <-chan struct{}) <-chan Element {
 	out := make(chan Element)
 	go func() {
 		defer close(out)
-		for e := range s.ct.Iterate(cancel) {
+		now := s.clock()
+		for e := range s.m.ct.Iterate(cancel) {
+			v, ok := liveAt(e.Value, now)
+			if !ok {
+				continue
+			}
 			entry := elePool.Get().(*ele)
-			entry.k = string(e.Key)
-			entry.v = e.Value
+			entry.k = e.Key
+			entry.v = v
 			entry.c = func() {
 				elePool.Put(entry)
 			}
@@ -1,7 +1,28 @@
 package sled_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cheekybits/is"
 
@@ -91,6 +112,43 @@ func TestNewGetSet(t *testing.T) {
 	is.NoErr(err)
 }
 
+// constHash64 hashes every key to the same value.
+type constHash64 struct {
+	hash.Hash64
+}
+
+func (constHash64) Sum64() uint64 {
+	return 7
+}
+
+func TestNewOptions(t *testing.T) {
+	is := is.New(t)
+	opts := [][]sled.Option{
+		{sled.WithHasher(func() hash.Hash64 { return constHash64{fnv.New64a()} })},
+		{sled.WithSeed(42)},
+		{sled.WithRandomSeed()},
+		{sled.WithHasher(fnv.New64), sled.WithRandomSeed()},
+		{sled.WithHashFunc(func(key string) uint64 { return uint64(len(key)) })},
+	}
+	for _, o := range opts {
+		sl := sled.New(o...)
+		for i := 0; i < 100; i++ {
+			is.NoErr(sl.Set(strconv.Itoa(i), i))
+		}
+		for i := 0; i < 100; i++ {
+			var v int
+			is.NoErr(sl.Get(strconv.Itoa(i), &v))
+			is.Equal(v, i)
+		}
+		is.Equal(sl.Size(), 100)
+		snap := sl.Snapshot(sled.ReadWrite)
+		var v int
+		is.NoErr(snap.Get("42", &v))
+		is.Equal(v, 42)
+		is.NoErr(sl.Close())
+	}
+}
+
 func TestInvalidTypeError(t *testing.T) {
 	is := is.New(t)
 	sl := sled.New()
@@ -166,6 +224,701 @@ func TestIterate(t *testing.T) {
 	is.NoErr(err)
 }
 
+func TestIterator(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	for i := 0; i < 100; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+	goroutines := runtime.NumGoroutine()
+
+	it := sl.Iterator()
+	sl.Set("foo", "bar")
+	is.Equal(it.Key(), "")
+	is.Nil(it.Value())
+	cnt := 0
+	for it.Next() {
+		is.Equal(it.Key(), strconv.Itoa(it.Value().(int)))
+		cnt++
+	}
+	is.Equal(cnt, 100)
+	is.Equal(it.Key(), "")
+	is.Nil(it.Value())
+
+	// Abandoning iterators part way leaves nothing running.
+	for i := 0; i < 10; i++ {
+		it := sl.Iterator()
+		is.True(it.Next())
+	}
+	is.True(runtime.NumGoroutine() <= goroutines)
+	err := sl.Close()
+	is.NoErr(err)
+}
+
+func TestAllKeys(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	for i := 0; i < 100; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+	snap := sl.Snapshot(sled.ReadOnly)
+	sl.Set("foo", "bar")
+
+	cnt := 0
+	for k, v := range snap.All() {
+		is.Equal(k, strconv.Itoa(v.(int)))
+		cnt++
+	}
+	is.Equal(cnt, 100)
+
+	cnt = 0
+	for k := range sl.Keys() {
+		is.NotEqual(k, "")
+		cnt++
+	}
+	is.Equal(cnt, 101)
+
+	// Breaking out early stops the traversal.
+	cnt = 0
+	for range sl.All() {
+		cnt++
+		if cnt == 3 {
+			break
+		}
+	}
+	is.Equal(cnt, 3)
+	err := sl.Close()
+	is.NoErr(err)
+}
+
+func TestRangeContext(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	for i := 0; i < 100; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+
+	cnt := 0
+	err := sl.Range(context.Background(), func(k string, v interface{}) bool {
+		cnt++
+		return true
+	})
+	is.NoErr(err)
+	is.Equal(cnt, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cnt = 0
+	err = sl.Range(ctx, func(k string, v interface{}) bool {
+		cnt++
+		if cnt == 10 {
+			cancel()
+		}
+		return true
+	})
+	is.Equal(cnt, 10)
+	is.True(errors.Is(err, context.Canceled))
+	var canceled sled.ErrCanceled
+	is.True(errors.As(err, &canceled))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	it := sl.IterateContext(ctx)
+	is.False(it.Next())
+	is.True(errors.Is(it.Err(), context.DeadlineExceeded))
+
+	it = sl.Iterator()
+	for it.Next() {
+	}
+	is.NoErr(it.Err())
+	err = sl.Close()
+	is.NoErr(err)
+}
+
+func TestIterateFrom(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	for i := 0; i < 100; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+	snap := sl.Snapshot(sled.ReadOnly)
+
+	seen := make(map[string]bool)
+	var token sled.Token
+	for pages := 0; ; pages++ {
+		is.True(pages < 10)
+		it, next, err := snap.IterateFrom(token, 10)
+		is.NoErr(err)
+		for it.Next() {
+			is.False(seen[it.Key()])
+			seen[it.Key()] = true
+		}
+		// Writes between pages are not seen by the snapshot.
+		sl.Set("foo"+strconv.Itoa(pages), pages)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	is.Equal(len(seen), 100)
+
+	_, _, err := snap.IterateFrom("bogus", 10)
+	is.Equal(err, sled.ErrInvalidToken)
+}
+
+func TestOrdered(t *testing.T) {
+	is := is.New(t)
+	for _, sl := range []sled.Sled{sled.New(), sled.New(sled.WithOrdered())} {
+		_, _, ok := sl.Min()
+		is.False(ok)
+		for i := 0; i < 100; i++ {
+			sl.Set(fmt.Sprintf("user:%03d", i), i)
+		}
+		sl.Delete("user:050")
+		sl.Update("user:051", func(old interface{}, exists bool) (interface{}, bool) {
+			return nil, false
+		})
+		sl.Set("admin", -1)
+
+		var keys []string
+		for k, v := range sl.Ascend("user:040", "user:060") {
+			is.Equal(k, fmt.Sprintf("user:%03d", v.(int)))
+			keys = append(keys, k)
+		}
+		is.Equal(len(keys), 18)
+		is.Equal(keys[0], "user:040")
+		is.Equal(keys[17], "user:059")
+		is.True(sort.StringsAreSorted(keys))
+
+		keys = keys[:0]
+		for k := range sl.Descend("user:060", "user:040") {
+			keys = append(keys, k)
+		}
+		is.Equal(len(keys), 18)
+		is.Equal(keys[0], "user:059")
+		is.Equal(keys[17], "user:040")
+
+		k, v, ok := sl.Min()
+		is.True(ok)
+		is.Equal(k, "admin")
+		is.Equal(v, -1)
+		k, _, ok = sl.Max()
+		is.True(ok)
+		is.Equal(k, "user:099")
+
+		snap := sl.Snapshot(sled.ReadOnly)
+		rw := sl.Snapshot(sled.ReadWrite)
+		sl.Set("zebra", 0)
+		sl.Delete("admin")
+		rw.Set("user:050", 50)
+
+		k, _, _ = snap.Min()
+		is.Equal(k, "admin")
+		k, _, _ = snap.Max()
+		is.Equal(k, "user:099")
+		k, _, _ = sl.Max()
+		is.Equal(k, "zebra")
+		k, _, _ = sl.Min()
+		is.Equal(k, "user:000")
+
+		cnt := 0
+		for range snap.Ascend("", "") {
+			cnt++
+		}
+		is.Equal(cnt, 99)
+		cnt = 0
+		for range rw.Ascend("user:049", "user:052") {
+			cnt++
+		}
+		is.Equal(cnt, 2)
+	}
+}
+
+func TestOrderedConcurrent(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New(sled.WithOrdered())
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				k := strconv.Itoa(i)
+				sl.Set(k, i)
+				if i%3 == 0 {
+					sl.Delete(k)
+				}
+				sl.SetIfNil(k, i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	scan := func(sl sled.Sled) {
+		var ordered, all []string
+		for k := range sl.Ascend("", "") {
+			ordered = append(ordered, k)
+		}
+		for k := range sl.Keys() {
+			all = append(all, k)
+		}
+		sort.Strings(all)
+		is.Equal(ordered, all)
+	}
+	scan(sl)
+
+	// A read-only snapshot scans the index of its source while it changes.
+	snap := sl.Snapshot(sled.ReadOnly)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				k := strconv.Itoa(i)
+				sl.Delete(k)
+				if i%2 == 0 {
+					sl.Set(k, i)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		scan(snap)
+	}
+	wg.Wait()
+	scan(snap)
+}
+
+func TestScanPrefix(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	ordered := sled.New(sled.WithOrdered())
+	for _, s := range []sled.Sled{sl, ordered} {
+		for tenant := 40; tenant < 45; tenant++ {
+			for session := 0; session < 10; session++ {
+				s.Set(fmt.Sprintf("tenant/%d/session/%d", tenant, session), session)
+			}
+		}
+		s.Set("tenant/42", "not under the prefix")
+		s.Set("\xff\xffa", 1)
+		s.Set("\xff\xff\xff", 2)
+	}
+	for _, s := range []sled.Sled{sl, ordered, sl.Snapshot(sled.ReadOnly), ordered.Snapshot(sled.ReadWrite)} {
+		var keys []string
+		for k, v := range s.ScanPrefix("tenant/42/") {
+			is.Equal(k, fmt.Sprintf("tenant/42/session/%d", v.(int)))
+			keys = append(keys, k)
+		}
+		is.Equal(len(keys), 10)
+
+		cnt := 0
+		for range s.ScanPrefix("\xff\xff") {
+			cnt++
+		}
+		is.Equal(cnt, 2)
+
+		cnt = 0
+		for range s.ScanPrefix("") {
+			cnt++
+		}
+		is.Equal(cnt, 53)
+
+		seq, err := s.ScanGlob("tenant/4[13]/session/*")
+		is.NoErr(err)
+		keys = keys[:0]
+		for k := range seq {
+			keys = append(keys, k)
+		}
+		is.Equal(len(keys), 20)
+
+		seq, err = s.ScanGlob("tenant/*")
+		is.NoErr(err)
+		keys = keys[:0]
+		for k := range seq {
+			keys = append(keys, k)
+		}
+		is.Equal(keys, []string{"tenant/42"})
+
+		_, err = s.ScanGlob("tenant/[")
+		is.Equal(err, path.ErrBadPattern)
+	}
+
+	// The index built by the first scan of a sled follows later writes.
+	sl.Delete("tenant/42/session/3")
+	sl.Set("tenant/42/session/10", 10)
+	var keys []string
+	for k := range sl.ScanPrefix("tenant/42/") {
+		keys = append(keys, k)
+	}
+	is.Equal(len(keys), 10)
+	is.True(sort.StringsAreSorted(keys))
+	is.Equal(keys[2], "tenant/42/session/10")
+}
+
+func TestTTL(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New(sled.WithOrdered())
+	defer sl.Close()
+	ttl := 50 * time.Millisecond
+
+	is.NoErr(sl.SetWithTTL("session", "a", ttl))
+	is.NoErr(sl.SetWithTTL("counter", 1, ttl))
+	sl.Set("forever", true)
+	sl.Set("later", 0)
+	is.True(sl.Expire("later", time.Hour))
+	is.False(sl.Expire("missing", time.Hour))
+
+	var s string
+	is.NoErr(sl.Get("session", &s))
+	is.Equal(s, "a")
+	is.NoErr(sl.Update("counter", func(old interface{}, exists bool) (interface{}, bool) {
+		return old.(int) + 1, true
+	}))
+	is.True(sl.CompareAndSwap("session", "a", "b"))
+	is.NoErr(sl.Get("session", &s))
+	is.Equal(s, "b")
+	snap := sl.Snapshot(sled.ReadOnly)
+
+	time.Sleep(2 * ttl)
+	// Update and CompareAndSwap kept the time to live.
+	is.Err(sl.Get("session", &s))
+	is.Err(sl.Get("counter", &s))
+	_, existed := sl.Delete("counter")
+	is.False(existed)
+	is.False(sl.Expire("session", time.Hour))
+	var keys []string
+	for k := range sl.Keys() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	is.Equal(keys, []string{"forever", "later"})
+	k, _, _ := sl.Min()
+	is.Equal(k, "forever")
+
+	// The snapshot keeps what it saw.
+	is.NoErr(snap.Get("session", &s))
+	is.Equal(s, "b")
+	is.Equal(len(slices.Collect(snap.Keys())), 4)
+
+	is.True(sl.SetIfNil("session", "c"))
+	is.NoErr(sl.Get("session", &s))
+	is.Equal(s, "c")
+
+	// Expired keys are removed in the background.
+	sl.SetWithTTL("gone", 1, time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for sl.Len() != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	is.Equal(sl.Len(), uint(3))
+
+	sl.SetWithTTL("session", "d", 0)
+	is.Err(sl.Get("session", &s))
+}
+
+func TestSnapshotTTL(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	defer sl.Close()
+	is.NoErr(sl.SetWithTTL("gone", 1, 20*time.Millisecond))
+	sl.Set("kept", 2)
+	ro := sl.Snapshot(sled.ReadOnly)
+
+	// A read-write snapshot removes the expired keys it inherited once it
+	// has been written.
+	for _, src := range []sled.Sled{sl, ro, sl.Snapshot(sled.ReadWrite)} {
+		snap := src.Snapshot(sled.ReadWrite)
+		is.Equal(snap.Len(), uint(2))
+		snap.Set("new", 3)
+		deadline := time.Now().Add(5 * time.Second)
+		for snap.Len() != 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		is.Equal(snap.Len(), uint(2))
+		var v int
+		is.Err(snap.Get("gone", &v))
+		snap.Close()
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	is := is.New(t)
+	for _, policy := range []sled.EvictionPolicy{nil, sled.NewLRU(), sled.NewLFU(), sled.NewTinyLFU(10)} {
+		var mu sync.Mutex
+		evicted := make(map[string]interface{})
+		opts := []sled.Option{
+			sled.WithMaxEntries(10),
+			sled.WithOnEvict(func(key string, value interface{}) {
+				mu.Lock()
+				evicted[key] = value
+				mu.Unlock()
+			}),
+		}
+		if policy != nil {
+			opts = append(opts, sled.WithEvictionPolicy(policy))
+		}
+		sl := sled.New(opts...)
+		for i := 0; i < 10; i++ {
+			sl.Set(strconv.Itoa(i), i)
+		}
+		snap := sl.Snapshot(sled.ReadOnly)
+		var v int
+		for r := 0; r < 3; r++ {
+			is.NoErr(sl.Get("0", &v))
+		}
+		for i := 10; i < 19; i++ {
+			sl.Set(strconv.Itoa(i), i)
+			is.Equal(sl.Len(), uint(10))
+		}
+		is.Equal(len(evicted), 9)
+		for k, v := range evicted {
+			is.Equal(k, strconv.Itoa(v.(int)))
+			is.Err(sl.Get(k, &v))
+		}
+		// The key read most was kept, by every policy.
+		is.NoErr(sl.Get("0", &v))
+		// Snapshots keep what they saw.
+		is.Equal(len(slices.Collect(snap.Keys())), 10)
+	}
+
+	// Keys that were deleted are not evicted.
+	sl := sled.New(sled.WithMaxEntries(2), sled.WithOnEvict(func(key string, value interface{}) {
+		t.Errorf("evicted %s", key)
+	}))
+	sl.Set("a", 1)
+	sl.Delete("a")
+	sl.Set("b", 2)
+	sl.Set("c", 3)
+
+	// The function may write to the sled, durable or not.
+	for _, durable := range []bool{false, true} {
+		var sl sled.Sled
+		opts := []sled.Option{
+			sled.WithMaxEntries(2),
+			sled.WithOnEvict(func(key string, value interface{}) {
+				sl.Delete(key + ".meta")
+			}),
+		}
+		if durable {
+			var err error
+			sl, err = sled.Open(t.TempDir(), opts...)
+			is.NoErr(err)
+		} else {
+			sl = sled.New(opts...)
+		}
+		sl.Set("a", 1)
+		sl.Set("a.meta", 1)
+		sl.Set("b", 2)
+		is.Equal(sl.Len(), uint(1))
+		var v int
+		is.NoErr(sl.Get("b", &v))
+		is.NoErr(sl.Close())
+	}
+}
+
+// text is a string whose estimated size is its length.
+type text string
+
+func (t text) SizeBytes() int64 { return int64(len(t)) }
+
+func TestMaxBytes(t *testing.T) {
+	is := is.New(t)
+	var evicted []string
+	sl := sled.New(sled.WithMaxBytes(100), sled.WithOnEvict(func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}))
+	// Each entry holds 10 bytes.
+	for i := 0; i < 10; i++ {
+		sl.Set(strconv.Itoa(i), text("123456789"))
+	}
+	is.Equal(len(evicted), 0)
+	sl.Set("0", text("1234567890"))
+	is.Equal(evicted, []string{"1"})
+	sl.Set("big", text(strings.Repeat("x", 97)))
+	is.Equal(sl.Len(), uint(1))
+}
+
+// countedValue counts the times it is measured.
+type countedValue struct {
+	n *int64
+}
+
+func (c countedValue) SizeBytes() int64 {
+	atomic.AddInt64(c.n, 1)
+	return 10
+}
+
+func TestMaxBytesAccounting(t *testing.T) {
+	is := is.New(t)
+	var evicted []string
+	sl := sled.New(sled.WithMaxBytes(260), sled.WithOnEvict(func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}))
+	// Each entry holds a one byte key and a 16 byte string header with 9
+	// bytes of data.
+	for i := 0; i < 10; i++ {
+		sl.Set(strconv.Itoa(i), "123456789")
+	}
+	is.Equal(sl.Bytes(), int64(260))
+	is.Equal(len(evicted), 0)
+	sl.Set("0", "1234567890")
+	is.Equal(evicted, []string{"1"})
+	sl.Set("big", strings.Repeat("x", 241))
+	is.Equal(sl.Len(), uint(1))
+	is.Equal(sl.Bytes(), int64(260))
+
+	// An entry is measured once, when it is written, and the same size is
+	// taken off when it goes, even if its value has grown meanwhile.
+	var measured int64
+	bounded := sled.New(sled.WithMaxBytes(1 << 20))
+	defer bounded.Close()
+	bounded.Set("c", countedValue{&measured})
+	is.Equal(bounded.Bytes(), int64(11))
+	is.Equal(bounded.Bytes(), int64(11))
+	is.Equal(atomic.LoadInt64(&measured), int64(1))
+	grows := map[int]int{0: 0}
+	bounded.Set("m", grows)
+	for i := 1; i < 100; i++ {
+		grows[i] = i
+	}
+	bounded.Delete("m")
+	is.Equal(bounded.Bytes(), int64(11))
+
+	// Every kind of write is accounted for.
+	is.NoErr(bounded.SetWithTTL("t", text("12345"), time.Hour))
+	is.True(bounded.Expire("c", time.Hour))
+	is.True(bounded.CompareAndSwap("t", text("12345"), text("1")))
+	is.NoErr(bounded.Update("c", func(old interface{}, exists bool) (interface{}, bool) {
+		return text("123"), true
+	}))
+	is.True(bounded.SetIfNil("n", text("1")))
+	_, loaded := bounded.GetOrSet("n", text("12"))
+	is.True(loaded)
+	is.True(bounded.Bytes() > 0)
+	is.Equal(bounded.Snapshot(sled.ReadOnly).Bytes(), bounded.Bytes())
+	rw := bounded.Snapshot(sled.ReadWrite)
+	defer rw.Close()
+	before := rw.Bytes()
+	rw.Delete("n")
+	is.Equal(rw.Bytes(), before-2)
+	is.True(bounded.CompareAndDelete("n", text("1")))
+	bounded.Delete("c")
+	bounded.Delete("t")
+	is.Equal(bounded.Bytes(), int64(0))
+
+	// A sled which is not bounded by bytes measures nothing as it is
+	// written.
+	measured = 0
+	unbounded := sled.New()
+	unbounded.Set("c", countedValue{&measured})
+	is.Equal(atomic.LoadInt64(&measured), int64(0))
+	is.Equal(unbounded.Bytes(), int64(11))
+}
+
+type sizedValue struct{}
+
+func (sizedValue) SizeBytes() int64 { return 100 }
+
+func TestBytes(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New(sled.WithOrdered())
+	is.Equal(sl.Bytes(), int64(0))
+
+	sl.Set("tenant/1/a", sizedValue{})
+	sl.Set("tenant/1/b", sizedValue{})
+	sl.Set("tenant/2/a", sizedValue{})
+	is.Equal(sl.Bytes(), int64(3*110))
+	sl.Set("tenant/2/a", "x")
+	is.Equal(sl.Bytes(), int64(2*110+10+17))
+
+	snap := sl.Snapshot(sled.ReadOnly)
+	sl.Delete("tenant/1/a")
+	sl.Update("tenant/1/b", func(old interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	sl.SetWithTTL("tenant/3/a", sizedValue{}, time.Hour)
+	is.True(sl.Bytes() > int64(10+17+110))
+
+	is.Equal(snap.Bytes(), int64(2*110+10+17))
+	is.Equal(snap.BytesByPrefix("tenant/1/", "tenant/2/", "tenant/"), map[string]int64{
+		"tenant/1/": 220,
+		"tenant/2/": 27,
+		"tenant/":   247,
+	})
+
+	sl.Delete("tenant/2/a")
+	sl.Delete("tenant/3/a")
+	is.Equal(sl.Bytes(), int64(0))
+}
+
+func TestMaxEntriesConcurrent(t *testing.T) {
+	sl := sled.New(sled.WithMaxEntries(100), sled.WithEvictionPolicy(sled.NewTinyLFU(100)))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			var v int
+			for i := 0; i < 1000; i++ {
+				k := strconv.Itoa(g*1000 + i)
+				sl.Set(k, i)
+				sl.Get(k, &v)
+				if i%4 == 0 {
+					sl.Delete(k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := sl.Len(); n > 100 {
+		t.Errorf("%d keys, want at most 100", n)
+	}
+}
+
+func TestParallelRange(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	for i := 0; i < 1000; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+	snap := sl.Snapshot(sled.ReadOnly)
+	for i := 1000; i < 2000; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	err := snap.ParallelRange(context.Background(), 4, func(k string, v interface{}) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		is.False(seen[k])
+		seen[k] = true
+		return true
+	})
+	is.NoErr(err)
+	is.Equal(len(seen), 1000)
+
+	var cnt int64
+	err = sl.ParallelRange(context.Background(), 4, func(k string, v interface{}) bool {
+		return atomic.AddInt64(&cnt, 1) < 10
+	})
+	is.NoErr(err)
+	is.True(atomic.LoadInt64(&cnt) < 2000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = sl.ParallelRange(ctx, 0, func(k string, v interface{}) bool {
+		return true
+	})
+	is.True(errors.Is(err, context.Canceled))
+
+	sum, err := sled.ParallelMapReduceSled(context.Background(), sl, 8, 0,
+		func(k string, v interface{}) int { return v.(int) },
+		func(a, b int) int { return a + b })
+	is.NoErr(err)
+	is.Equal(sum, 1999*2000/2)
+}
+
 func TestSetIfNil(t *testing.T) {
 	is := is.New(t)
 	sl := sled.New()
@@ -178,6 +931,194 @@ func TestSetIfNil(t *testing.T) {
 	is.NoErr(err)
 }
 
+func TestSetIfNilRace(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+
+	var wg sync.WaitGroup
+	var wins int32
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				if sl.SetIfNil("owner"+strconv.Itoa(n), i) {
+					atomic.AddInt32(&wins, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	is.Equal(wins, 100)
+	err := sl.Close()
+	is.NoErr(err)
+}
+
+func TestGetOrSet(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+
+	actual, loaded := sl.GetOrSet("foo", "bar")
+	is.False(loaded)
+	is.Equal(actual, "bar")
+	actual, loaded = sl.GetOrSet("foo", "baz")
+	is.True(loaded)
+	is.Equal(actual, "bar")
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 64)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = sl.GetOrSet("owner", i)
+		}(i)
+	}
+	wg.Wait()
+	for _, r := range results {
+		is.Equal(r, results[0])
+	}
+	err := sl.Close()
+	is.NoErr(err)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+
+	is.False(sl.CompareAndSwap("foo", nil, "bar"))
+	sl.Set("foo", "bar")
+	is.False(sl.CompareAndSwap("foo", "baz", "qux"))
+	is.True(sl.CompareAndSwap("foo", "bar", "qux"))
+	var v string
+	is.NoErr(sl.Get("foo", &v))
+	is.Equal(v, "qux")
+
+	// Non-comparable values never compare equal.
+	sl.Set("slice", []int{1})
+	is.False(sl.CompareAndSwap("slice", []int{1}, []int{2}))
+
+	// Concurrent increments through CompareAndSwap are never lost.
+	sl.Set("counter", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				for {
+					var c int
+					sl.Get("counter", &c)
+					if sl.CompareAndSwap("counter", c, c+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var c int
+	is.NoErr(sl.Get("counter", &c))
+	is.Equal(c, 800)
+	err := sl.Close()
+	is.NoErr(err)
+
+	// A failed compare on a key with a time to live writes nothing, so it
+	// does not make the key recently used.
+	bounded := sled.New(sled.WithMaxEntries(2))
+	defer bounded.Close()
+	is.NoErr(bounded.SetWithTTL("a", 1, time.Hour))
+	bounded.Set("b", 2)
+	is.False(bounded.CompareAndSwap("a", 2, 3))
+	bounded.Set("c", 3)
+	is.Err(bounded.Get("a", &c))
+	is.True(bounded.CompareAndSwap("b", 2, 4))
+	is.NoErr(bounded.Get("b", &c))
+	is.Equal(c, 4)
+
+	// The result is what the compare decided when the value was swapped,
+	// however the Equaler answers when asked again.
+	sl = sled.New()
+	defer sl.Close()
+	sl.Set("foo", "bar")
+	is.True(sl.CompareAndSwap("foo", &equalOnce{}, "qux"))
+	is.NoErr(sl.Get("foo", &v))
+	is.Equal(v, "qux")
+	is.True(sl.CompareAndDelete("foo", &equalOnce{}))
+	is.Err(sl.Get("foo", &v))
+}
+
+// equalOnce is equal to any value the first time it is compared, and to none
+// after.
+type equalOnce struct {
+	calls int
+}
+
+func (e *equalOnce) Equal(v interface{}) bool {
+	e.calls++
+	return e.calls == 1
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+
+	is.False(sl.CompareAndDelete("foo", "bar"))
+	sl.Set("foo", "bar")
+	is.False(sl.CompareAndDelete("foo", "baz"))
+	is.True(sl.CompareAndDelete("foo", "bar"))
+	var v string
+	is.Err(sl.Get("foo", &v))
+	err := sl.Close()
+	is.NoErr(err)
+}
+
+func TestUpdate(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+
+	appendTo := func(s string) sled.UpdateFunc {
+		return func(old interface{}, exists bool) (interface{}, bool) {
+			if !exists {
+				return []string{s}, true
+			}
+			return append(append([]string{}, old.([]string)...), s), true
+		}
+	}
+	is.NoErr(sl.Update("list", appendTo("a")))
+	is.NoErr(sl.Update("list", appendTo("b")))
+	var list []string
+	is.NoErr(sl.Get("list", &list))
+	is.Equal(list, []string{"a", "b"})
+
+	// Conditional delete.
+	err := sl.Update("list", func(old interface{}, exists bool) (interface{}, bool) {
+		return old, len(old.([]string)) < 2
+	})
+	is.NoErr(err)
+	is.Err(sl.Get("list", &list))
+	err = sl.Close()
+	is.NoErr(err)
+}
+
+func TestLen(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	for i := 0; i < 100; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+	sl.Delete("0")
+	sl.SetIfNil("1", 1)
+	is.Equal(sl.Len(), 99)
+	is.Equal(sl.Size(), 99)
+	snap := sl.Snapshot(sled.ReadOnly)
+	sl.Delete("1")
+	is.Equal(snap.Len(), 99)
+	is.Equal(sl.Len(), 98)
+	err := sl.Close()
+	is.NoErr(err)
+}
+
 func TestDelete(t *testing.T) {
 	is := is.New(t)
 	sl := sled.New()
@@ -221,3 +1162,737 @@ func TestSnapshot(t *testing.T) {
 	err = sl.Close()
 	is.NoErr(err)
 }
+
+func TestOpen(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir)
+	is.NoErr(err)
+	for i := 0; i < 100; i++ {
+		is.NoErr(sl.Set(strconv.Itoa(i), i))
+	}
+	sl.Delete("7")
+	is.True(sl.CompareAndSwap("8", 8, "eight"))
+	is.NoErr(sl.Update("9", func(v interface{}, ok bool) (interface{}, bool) {
+		return v.(int) * 10, true
+	}))
+	is.NoErr(sl.SetWithTTL("ttl", "long", time.Hour))
+	is.NoErr(sl.SetWithTTL("gone", "short", time.Millisecond))
+	is.NoErr(sl.Close())
+	time.Sleep(2 * time.Millisecond)
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	is.Equal(sl.Len(), uint(100))
+	var n int
+	is.NoErr(sl.Get("42", &n))
+	is.Equal(n, 42)
+	is.NoErr(sl.Get("9", &n))
+	is.Equal(n, 90)
+	is.Err(sl.Get("7", &n))
+	var s string
+	is.NoErr(sl.Get("8", &s))
+	is.Equal(s, "eight")
+	is.NoErr(sl.Get("ttl", &s))
+	is.Equal(s, "long")
+	is.Err(sl.Get("gone", &s))
+}
+
+func TestOpenTornTail(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithSyncPolicy(sled.SyncNever))
+	is.NoErr(err)
+	is.NoErr(sl.Set("a", "1"))
+	is.NoErr(sl.Set("b", "2"))
+	is.NoErr(sl.Close())
+
+	// Cut the last record short, as a crash in the middle of its write
+	// would.
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	is.Equal(len(paths), 1)
+	info, err := os.Stat(paths[0])
+	is.NoErr(err)
+	is.NoErr(os.Truncate(paths[0], info.Size()-3))
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	var v string
+	is.NoErr(sl.Get("a", &v))
+	is.Err(sl.Get("b", &v))
+	is.NoErr(sl.Set("c", "3"))
+	is.NoErr(sl.Close())
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	is.Equal(sl.Len(), uint(2))
+	is.NoErr(sl.Get("c", &v))
+	is.Equal(v, "3")
+}
+
+func TestOpenSegments(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithSegmentSize(256), sled.WithSyncInterval(time.Millisecond))
+	is.NoErr(err)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				is.NoErr(sl.Set(strconv.Itoa(i), w))
+			}
+		}(w)
+	}
+	wg.Wait()
+	want := make(map[string]interface{})
+	for k, v := range sl.All() {
+		want[k] = v
+	}
+	is.NoErr(sl.Close())
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	is.True(len(paths) > 1)
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	got := make(map[string]interface{})
+	for k, v := range sl.All() {
+		got[k] = v
+	}
+	is.Equal(got, want)
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithSegmentSize(64))
+	is.NoErr(err)
+	for i := 0; i < 10; i++ {
+		is.NoErr(sl.Set(strconv.Itoa(i), i))
+	}
+	is.NoErr(sl.Close())
+
+	// A damaged record followed by more of the log is not a torn tail.
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	is.True(len(paths) > 1)
+	data, err := os.ReadFile(paths[0])
+	is.NoErr(err)
+	data[len(data)-1] ^= 0xff
+	is.NoErr(os.WriteFile(paths[0], data, 0o644))
+	_, err = sled.Open(dir)
+	is.True(errors.Is(err, sled.ErrCorruptLog))
+}
+
+func TestOpenCorruptLastSegment(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir)
+	is.NoErr(err)
+	for i := 0; i < 10; i++ {
+		is.NoErr(sl.Set(strconv.Itoa(i), i))
+	}
+	is.NoErr(sl.Close())
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	is.Equal(len(paths), 1)
+	data, err := os.ReadFile(paths[0])
+	is.NoErr(err)
+
+	// Damage the length, the checksum and the payload of the first record,
+	// past the segment header. The records after it show it was not torn.
+	header := len("SLEDWLOG") + 3 + len("gob")
+	for _, off := range []int{header, header + 4, header + 10} {
+		damaged := slices.Clone(data)
+		damaged[off] ^= 0xff
+		is.NoErr(os.WriteFile(paths[0], damaged, 0o644))
+		_, err = sled.Open(dir)
+		is.True(errors.Is(err, sled.ErrCorruptLog))
+		info, err := os.Stat(paths[0])
+		is.NoErr(err)
+		is.Equal(info.Size(), int64(len(data)))
+	}
+}
+
+func TestOpenBounded(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithMaxEntries(2))
+	is.NoErr(err)
+	is.NoErr(sl.Set("a", 1))
+	is.NoErr(sl.Set("b", 2))
+	var v int
+	is.NoErr(sl.Get("a", &v))
+	is.NoErr(sl.Set("c", 3))
+	is.Err(sl.Get("b", &v))
+	is.NoErr(sl.Close())
+
+	// The logged eviction of b is replayed; nothing else is evicted.
+	sl, err = sled.Open(dir, sled.WithMaxEntries(2))
+	is.NoErr(err)
+	is.Equal(sl.Len(), uint(2))
+	is.NoErr(sl.Get("a", &v))
+	is.NoErr(sl.Get("c", &v))
+	is.NoErr(sl.Close())
+
+	// Lower limits evict once the log is replayed.
+	sl, err = sled.Open(dir, sled.WithMaxEntries(1))
+	is.NoErr(err)
+	is.Equal(sl.Len(), uint(1))
+	is.NoErr(sl.Close())
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	is.Equal(sl.Len(), uint(1))
+}
+
+func TestWriteToLoad(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	defer sl.Close()
+	for i := 0; i < 5000; i++ {
+		sl.Set(strconv.Itoa(i), strings.Repeat("x", i%100))
+	}
+	sl.Set("bytes", []byte("raw"))
+	sl.SetWithTTL("ttl", 1.5, time.Hour)
+	sl.SetWithTTL("gone", true, time.Nanosecond)
+	snap := sl.Snapshot(sled.ReadOnly)
+	sl.Set("later", "not in the snapshot")
+
+	var buf bytes.Buffer
+	n, err := snap.WriteTo(&buf)
+	is.NoErr(err)
+	is.Equal(n, int64(buf.Len()))
+	file := buf.Bytes()
+
+	loaded, err := sled.Load(bytes.NewReader(file))
+	is.NoErr(err)
+	defer loaded.Close()
+	is.Equal(loaded.Len(), uint(5002))
+	want := make(map[string]interface{})
+	for k, v := range snap.All() {
+		want[k] = v
+	}
+	got := make(map[string]interface{})
+	for k, v := range loaded.All() {
+		got[k] = v
+	}
+	is.Equal(got, want)
+	var f float64
+	is.NoErr(loaded.Get("ttl", &f))
+	is.Equal(f, 1.5)
+	is.True(loaded.Expire("ttl", time.Hour))
+
+	// A live sled writes a snapshot of itself.
+	buf.Reset()
+	_, err = sl.WriteTo(&buf)
+	is.NoErr(err)
+	loaded, err = sled.Load(&buf)
+	is.NoErr(err)
+	is.Equal(loaded.Len(), uint(5003))
+
+	flipped := slices.Clone(file)
+	flipped[len(file)/2] ^= 1
+	for _, damaged := range [][]byte{
+		append([]byte("NOTSLED!"), file[8:]...),
+		file[:len(file)-100],
+		flipped,
+		nil,
+	} {
+		_, err := sled.Load(bytes.NewReader(damaged))
+		is.True(errors.Is(err, sled.ErrInvalidSnapshot))
+	}
+}
+
+func TestLoadVersion1(t *testing.T) {
+	is := is.New(t)
+	// A version 1 file holds gob streams of interfaces, with no type names.
+	var block []byte
+	entries := []struct {
+		key string
+		at  int64
+		v   interface{}
+	}{
+		{"a", 0, "one"},
+		{"b", time.Now().Add(time.Hour).UnixNano(), 2},
+	}
+	for _, e := range entries {
+		var value bytes.Buffer
+		is.NoErr(gob.NewEncoder(&value).Encode(&e.v))
+		block = binary.AppendUvarint(block, uint64(len(e.key)))
+		block = append(block, e.key...)
+		block = binary.AppendVarint(block, e.at)
+		block = binary.AppendUvarint(block, uint64(value.Len()))
+		block = append(block, value.Bytes()...)
+	}
+	file := append([]byte("SLEDSNAP"), 1, 0, 3)
+	file = append(file, "gob"...)
+	file = binary.LittleEndian.AppendUint64(file, uint64(len(entries)))
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(block)))
+	file = binary.LittleEndian.AppendUint32(file, crc32.Checksum(block, crc32.MakeTable(crc32.Castagnoli)))
+	file = append(file, block...)
+	file = append(file, make([]byte, 8)...)
+
+	sl, err := sled.Load(bytes.NewReader(file))
+	is.NoErr(err)
+	defer sl.Close()
+	var s string
+	is.NoErr(sl.Get("a", &s))
+	is.Equal(s, "one")
+	var n int
+	is.NoErr(sl.Get("b", &n))
+	is.Equal(n, 2)
+	is.True(sl.Expire("b", time.Hour))
+}
+
+type point struct {
+	X, Y int
+}
+
+func init() {
+	sled.RegisterType(point{})
+}
+
+func TestCodecs(t *testing.T) {
+	is := is.New(t)
+	for _, codec := range []sled.Codec{sled.GobCodec{}, sled.JSONCodec{}} {
+		sl := sled.New(sled.WithCodec(codec))
+		sl.Set("point", point{1, 2})
+		sl.Set("int", 42)
+		sl.Set("when", time.Unix(1500000000, 0).UTC())
+		var buf bytes.Buffer
+		_, err := sl.WriteTo(&buf)
+		is.NoErr(err)
+
+		// Load finds the codec by the name in the file.
+		loaded, err := sled.Load(&buf)
+		is.NoErr(err)
+		var p point
+		is.NoErr(loaded.Get("point", &p))
+		is.Equal(p, point{1, 2})
+		var n int
+		is.NoErr(loaded.Get("int", &n))
+		is.Equal(n, 42)
+		var when time.Time
+		is.NoErr(loaded.Get("when", &when))
+		is.True(when.Equal(time.Unix(1500000000, 0)))
+		var s string
+		is.Err(loaded.Get("point", &s))
+	}
+
+	sl := sled.New(sled.WithCodec(sled.RawCodec{}))
+	sl.Set("bytes", []byte("raw"))
+	var buf bytes.Buffer
+	_, err := sl.WriteTo(&buf)
+	is.NoErr(err)
+	sl.Set("string", "not bytes")
+	_, err = sl.WriteTo(io.Discard)
+	is.Err(err)
+}
+
+func TestOpenCodec(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithCodec(sled.JSONCodec{}))
+	is.NoErr(err)
+	is.NoErr(sl.Set("a", point{1, 2}))
+	is.NoErr(sl.Close())
+
+	// Segments keep the codec they were written with.
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	is.NoErr(sl.Set("b", point{3, 4}))
+	is.NoErr(sl.Close())
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	var p point
+	is.NoErr(sl.Get("a", &p))
+	is.Equal(p, point{1, 2})
+	is.NoErr(sl.Get("b", &p))
+	is.Equal(p, point{3, 4})
+
+	type unregistered struct{}
+	is.Err(sl.Set("c", unregistered{}))
+}
+
+func TestOpenCodecEmptySegment(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir)
+	is.NoErr(err)
+	is.NoErr(sl.Close())
+
+	// The empty segment left by the first sled is replaced.
+	sl, err = sled.Open(dir, sled.WithCodec(sled.JSONCodec{}))
+	is.NoErr(err)
+	is.NoErr(sl.Set("a", 1.5))
+	is.NoErr(sl.Close())
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	var f float64
+	is.NoErr(sl.Get("a", &f))
+	is.Equal(f, 1.5)
+}
+
+func TestOpenUnencodable(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir)
+	is.NoErr(err)
+	is.NoErr(sl.Set("a", 1))
+
+	// A value which cannot be logged is refused, and changes nothing.
+	type unregistered struct{}
+	is.Err(sl.Set("b", unregistered{}))
+	is.Err(sl.SetWithTTL("b", unregistered{}, time.Hour))
+	is.Err(sl.Update("a", func(interface{}, bool) (interface{}, bool) {
+		return unregistered{}, true
+	}))
+	_, err = sl.TrySetIfNil("b", func() {})
+	is.Err(err)
+	is.False(sl.SetIfNil("b", unregistered{}))
+	is.False(sl.CompareAndSwap("a", 1, unregistered{}))
+	actual, loaded := sl.GetOrSet("a", unregistered{})
+	is.True(loaded)
+	is.Equal(actual, 1)
+	var n int
+	is.Err(sl.Get("b", &n))
+	is.NoErr(sl.Get("a", &n))
+	is.Equal(n, 1)
+
+	// The log carries on.
+	is.NoErr(sl.Set("c", 3))
+	is.NoErr(sl.Checkpoint())
+	is.NoErr(sl.Set("d", 4))
+	is.NoErr(sl.Close())
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	is.Equal(sl.Len(), uint(3))
+	is.NoErr(sl.Get("a", &n))
+	is.Equal(n, 1)
+	is.NoErr(sl.Get("d", &n))
+	is.Equal(n, 4)
+}
+
+func TestCheckpoint(t *testing.T) {
+	is := is.New(t)
+	is.Equal(sled.New().Checkpoint(), sled.ErrNotDurable)
+
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithSegmentSize(1024))
+	is.NoErr(err)
+	for i := 0; i < 500; i++ {
+		is.NoErr(sl.Set(strconv.Itoa(i), i))
+	}
+	before, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	is.NoErr(sl.Checkpoint())
+	after, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	is.True(len(before) > 1)
+	is.Equal(len(after), 1)
+	is.NoErr(sl.Checkpoint()) // nothing written since
+	is.Equal(sl.Snapshot(sled.ReadOnly).Checkpoint(), sled.ErrNotDurable)
+
+	// The tail of the log after the checkpoint is replayed over it.
+	sl.Delete("0")
+	is.NoErr(sl.Set("1", "one"))
+	is.NoErr(sl.Close())
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	is.Equal(sl.Len(), uint(499))
+	var s string
+	is.NoErr(sl.Get("1", &s))
+	is.Equal(s, "one")
+	is.NoErr(sl.Close())
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	sl, err := sled.Open(dir, sled.WithCheckpointInterval(time.Millisecond))
+	is.NoErr(err)
+	for i := 0; i < 100; i++ {
+		is.NoErr(sl.Set(strconv.Itoa(i), i))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "checkpoint")); err == nil || time.Now().After(deadline) {
+			is.NoErr(err)
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	is.NoErr(sl.Set("last", true))
+	is.NoErr(sl.Close())
+
+	sl, err = sled.Open(dir)
+	is.NoErr(err)
+	defer sl.Close()
+	is.Equal(sl.Len(), uint(101))
+}
+
+func TestTrie(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	defer sl.Close()
+	for i := 0; i < 5000; i++ {
+		sl.Set(strconv.Itoa(i), i)
+	}
+	sl.Set("point", point{1, 2})
+	sl.Set("nil", nil)
+	sl.SetWithTTL("ttl", "soon", time.Hour)
+	sl.SetWithTTL("gone", true, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "sled.trie")
+	f, err := os.Create(path)
+	is.NoErr(err)
+	n, err := sled.WriteTrie(f, sl)
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	fi, err := os.Stat(path)
+	is.NoErr(err)
+	is.Equal(n, fi.Size())
+
+	tr, err := sled.OpenTrie(path)
+	is.NoErr(err)
+	defer tr.Close()
+	is.Equal(tr.Len(), uint(5003))
+	is.Equal(tr.Bytes(), sl.Bytes())
+
+	var i int
+	is.NoErr(tr.Get("4321", &i))
+	is.Equal(i, 4321)
+	var p point
+	is.NoErr(tr.Get("point", &p))
+	is.Equal(p, point{1, 2})
+	var s string
+	is.NoErr(tr.Get("ttl", &s))
+	is.Equal(s, "soon")
+	is.Err(tr.Get("gone", &s))
+	is.Err(tr.Get("missing", &s))
+	is.Err(tr.Get("4321", &s))
+
+	want := make(map[string]interface{})
+	for k, v := range sl.All() {
+		want[k] = v
+	}
+	got := make(map[string]interface{})
+	for k, v := range tr.All() {
+		got[k] = v
+	}
+	is.Equal(got, want)
+
+	// Paging visits every key once.
+	seen := make(map[string]int)
+	var token sled.Token
+	for {
+		page, next, err := tr.IterateFrom(token, 97)
+		is.NoErr(err)
+		is.Equal(page.Key(), "")
+		for page.Next() {
+			seen[page.Key()]++
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	is.Equal(len(seen), len(want))
+	for k, n := range seen {
+		is.Equal(n, 1)
+		is.True(want[k] != nil || k == "nil")
+	}
+
+	var count int64
+	is.NoErr(tr.ParallelRange(context.Background(), 4, func(string, interface{}) bool {
+		atomic.AddInt64(&count, 1)
+		return true
+	}))
+	is.Equal(count, int64(len(want)))
+
+	var keys []string
+	for k := range tr.ScanPrefix("123") {
+		keys = append(keys, k)
+	}
+	is.Equal(keys, []string{"123", "1230", "1231", "1232", "1233", "1234", "1235", "1236", "1237", "1238", "1239"})
+	max, _, ok := tr.Max()
+	is.True(ok)
+	is.Equal(max, "ttl")
+
+	// Writes change nothing.
+	is.Equal(tr.Set("1", 2), sled.ErrReadOnly)
+	is.Equal(tr.SetWithTTL("1", 2, time.Hour), sled.ErrReadOnly)
+	is.Equal(tr.Update("1", func(v interface{}, ok bool) (interface{}, bool) { return 2, true }), sled.ErrReadOnly)
+	is.False(tr.SetIfNil("new", 1))
+	is.False(tr.CompareAndSwap("1", 1, 2))
+	_, ok = tr.Delete("1")
+	is.False(ok)
+	is.NoErr(tr.Get("1", &i))
+	is.Equal(i, 1)
+
+	// A copy is writable, and a file written from the trie loads back.
+	rw := tr.Snapshot(sled.ReadWrite)
+	is.NoErr(rw.Set("1", 2))
+	is.Equal(rw.Len(), uint(5003))
+	is.True(rw.Expire("ttl", time.Hour))
+	var buf bytes.Buffer
+	_, err = tr.WriteTo(&buf)
+	is.NoErr(err)
+	loaded, err := sled.Load(&buf)
+	is.NoErr(err)
+	is.Equal(loaded.Len(), uint(5003))
+
+	// A trie written from the trie keeps the time to live of its keys,
+	// which is counted in the size of their entries.
+	path2 := filepath.Join(t.TempDir(), "copy.trie")
+	f, err = os.Create(path2)
+	is.NoErr(err)
+	_, err = sled.WriteTrie(f, tr)
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	tr2, err := sled.OpenTrie(path2)
+	is.NoErr(err)
+	defer tr2.Close()
+	is.Equal(tr2.Len(), uint(5003))
+	is.Equal(tr2.Bytes(), tr.Bytes())
+
+	// The mapping outlives the sled while a snapshot holds it.
+	ro := tr.Snapshot(sled.ReadOnly)
+	is.NoErr(tr.Close())
+	is.True(errors.Is(tr.Get("1", &i), os.ErrClosed))
+	is.NoErr(ro.Get("1", &i))
+	is.NoErr(ro.Close())
+	is.True(errors.Is(ro.Get("1", &i), os.ErrClosed))
+
+	data, err := os.ReadFile(path)
+	is.NoErr(err)
+	for _, damaged := range [][]byte{
+		append([]byte("NOTSLED!"), data[8:]...),
+		data[:len(data)-1],
+		nil,
+	} {
+		is.NoErr(os.WriteFile(path, damaged, 0o644))
+		_, err := sled.OpenTrie(path)
+		is.True(errors.Is(err, sled.ErrCorruptTrie))
+	}
+}
+
+func TestReadOnlyWrites(t *testing.T) {
+	is := is.New(t)
+	sl := sled.New()
+	defer sl.Close()
+	sl.Set("a", 1)
+	sl.SetWithTTL("ttl", 2, time.Hour)
+
+	durable, err := sled.Open(t.TempDir())
+	is.NoErr(err)
+	defer durable.Close()
+	durable.Set("a", 1)
+	durable.SetWithTTL("ttl", 2, time.Hour)
+
+	path := filepath.Join(t.TempDir(), "sled.trie")
+	f, err := os.Create(path)
+	is.NoErr(err)
+	_, err = sled.WriteTrie(f, sl)
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	trie, err := sled.OpenTrie(path)
+	is.NoErr(err)
+	defer trie.Close()
+
+	sleds := map[string]sled.Sled{
+		"snapshot":         sl.Snapshot(sled.ReadOnly),
+		"durable snapshot": durable.Snapshot(sled.ReadOnly),
+		"trie":             trie,
+	}
+	// Each write changes nothing, and returns the error wanted, which is nil
+	// for those which only report whether they changed anything.
+	writes := []struct {
+		name  string
+		write func(sl sled.Sled) (changed bool, err error)
+		want  error
+	}{
+		{"Set", func(sl sled.Sled) (bool, error) {
+			return false, sl.Set("a", 3)
+		}, sled.ErrReadOnly},
+		{"SetWithTTL", func(sl sled.Sled) (bool, error) {
+			return false, sl.SetWithTTL("a", 3, time.Hour)
+		}, sled.ErrReadOnly},
+		{"SetWithTTL zero", func(sl sled.Sled) (bool, error) {
+			return false, sl.SetWithTTL("a", 3, 0)
+		}, sled.ErrReadOnly},
+		{"Update", func(sl sled.Sled) (bool, error) {
+			return false, sl.Update("a", func(interface{}, bool) (interface{}, bool) { return 3, true })
+		}, sled.ErrReadOnly},
+		{"Expire", func(sl sled.Sled) (bool, error) {
+			return sl.Expire("a", time.Hour), nil
+		}, nil},
+		{"Expire zero", func(sl sled.Sled) (bool, error) {
+			return sl.Expire("a", 0), nil
+		}, nil},
+		{"SetIfNil", func(sl sled.Sled) (bool, error) {
+			return sl.SetIfNil("b", 3), nil
+		}, nil},
+		{"TrySetIfNil", func(sl sled.Sled) (bool, error) {
+			return sl.TrySetIfNil("b", 3)
+		}, sled.ErrReadOnly},
+		{"GetOrSet", func(sl sled.Sled) (bool, error) {
+			v, loaded := sl.GetOrSet("b", 3)
+			return loaded || v != nil, nil
+		}, nil},
+		{"CompareAndSwap", func(sl sled.Sled) (bool, error) {
+			return sl.CompareAndSwap("a", 1, 3), nil
+		}, nil},
+		{"CompareAndSwap ttl", func(sl sled.Sled) (bool, error) {
+			return sl.CompareAndSwap("ttl", 2, 3), nil
+		}, nil},
+		{"CompareAndDelete", func(sl sled.Sled) (bool, error) {
+			return sl.CompareAndDelete("a", 1), nil
+		}, nil},
+		{"Delete", func(sl sled.Sled) (bool, error) {
+			_, existed := sl.Delete("a")
+			return existed, nil
+		}, nil},
+		{"TryDelete", func(sl sled.Sled) (bool, error) {
+			_, existed, err := sl.TryDelete("a")
+			return existed, err
+		}, sled.ErrReadOnly},
+		{"Checkpoint", func(sl sled.Sled) (bool, error) {
+			return false, sl.Checkpoint()
+		}, sled.ErrNotDurable},
+		{"WriteTo", func(sl sled.Sled) (bool, error) {
+			_, err := sl.WriteTo(io.Discard)
+			return false, err
+		}, nil},
+	}
+	for name, ro := range sleds {
+		for _, w := range writes {
+			changed, err := w.write(ro)
+			if changed || err != w.want {
+				t.Errorf("%s: %s: changed %v, err %v", name, w.name, changed, err)
+			}
+			var a, ttl int
+			if ro.Get("a", &a) != nil || a != 1 || ro.Get("ttl", &ttl) != nil || ttl != 2 || ro.Len() != 2 {
+				t.Errorf("%s: %s: wrote to a read-only sled", name, w.name)
+			}
+		}
+
+		// A read-write snapshot of a read-only one is writable.
+		rw := ro.Snapshot(sled.ReadWrite)
+		is.NoErr(rw.Set("a", 3))
+		is.True(rw.SetIfNil("b", 3))
+		is.True(rw.Expire("ttl", time.Minute))
+		is.Equal(rw.Len(), uint(3))
+		var a int
+		is.NoErr(ro.Get("a", &a))
+		is.Equal(a, 1)
+	}
+}
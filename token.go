@@ -0,0 +1,156 @@
+package sled
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// Token is an opaque position in the iteration order of a HashMap or sled,
+// as returned by IterateFrom. The empty Token is the position before the
+// first entry.
+//
+// Entries are visited in the order of their key hashes, so a Token holds
+// the hash of the last entry visited and, for keys whose hashes collide, its
+// index among them. It is only meaningful to the map it came from, as key
+// hashes are seeded per process.
+type Token string
+
+// ErrInvalidToken is returned by IterateFrom for a Token it did not create.
+var ErrInvalidToken = errors.New("invalid iteration token")
+
+// tokenLen is the length of a decoded Token: the hash and the L-node index.
+const tokenLen = 8 + 4
+
+func makeToken(hash uint64, index int) Token {
+	var b [tokenLen]byte
+	binary.BigEndian.PutUint64(b[:8], hash)
+	binary.BigEndian.PutUint32(b[8:], uint32(index))
+	return Token(base64.RawURLEncoding.EncodeToString(b[:]))
+}
+
+func (t Token) decode() (hash uint64, index int, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(string(t))
+	if err != nil || len(b) != tokenLen {
+		return 0, 0, ErrInvalidToken
+	}
+	return binary.BigEndian.Uint64(b[:8]), int(binary.BigEndian.Uint32(b[8:])), nil
+}
+
+// hashBefore reports whether hash a is visited before hash b. C-nodes order
+// their branches by w bits of the hash at a time, starting with the lowest.
+func hashBefore(a, b uint64) bool {
+	for lev := uint(0); lev < exp2; lev += w {
+		ca, cb := (a>>lev)&0x3f, (b>>lev)&0x3f
+		if ca != cb {
+			return ca < cb
+		}
+	}
+	return false
+}
+
+// seekCursor returns a cursor over the entries of a read-only Ctrie which
+// come after the entry at position index among those with the given hash.
+func seekCursor[K, V any](c *ctrie[K, V], hash uint64, index int) *cursor[K, V] {
+	cur := &cursor[K, V]{c: c}
+	i := c.readRoot()
+	for lev := uint(0); i != nil; lev += w {
+		main := gcasRead(i, c)
+		i = nil
+		switch {
+		case main.cNode != nil:
+			cn := main.cNode
+			flag, pos := flagPos(hash, lev, cn.bmp)
+			if cn.bmp&flag == 0 {
+				cur.stack = append(cur.stack, cn.array[pos:])
+				break
+			}
+			cur.stack = append(cur.stack, cn.array[pos+1:])
+			switch b := cn.array[pos].(type) {
+			case *iNode[K, V]:
+				i = b
+			case *sNode[K, V]:
+				if hashBefore(hash, b.entry.hash) {
+					cur.pending = append(cur.pending, b.entry)
+				}
+			}
+		case main.tNode != nil:
+			if hashBefore(hash, main.tNode.entry.hash) {
+				cur.pending = append(cur.pending, main.tNode.entry)
+			}
+		case main.lNode != nil:
+			for j, sn := range main.lNode.Map(func(sn interface{}) interface{} {
+				return sn
+			}) {
+				e := sn.(*sNode[K, V]).entry
+				if j > index && e.hash == hash || hashBefore(hash, e.hash) {
+					cur.pending = append(cur.pending, e)
+				}
+			}
+		}
+	}
+	return cur
+}
+
+// iterateFrom collects up to limit entries of a read-only Ctrie which follow
+// the position token, and returns a cursor over them along with the Token of
+// the last one. The returned Token is empty once the Ctrie is exhausted.
+func iterateFrom[K, V any](c *ctrie[K, V], token Token, limit int) (*cursor[K, V], Token, error) {
+	var (
+		src   *cursor[K, V]
+		hash  uint64
+		index int
+	)
+	if token == "" {
+		src = &cursor[K, V]{c: c}
+		src.push(c.readRoot())
+		index = -1
+	} else {
+		var err error
+		if hash, index, err = token.decode(); err != nil {
+			return nil, "", err
+		}
+		src = seekCursor(c, hash, index)
+	}
+
+	page := &cursor[K, V]{c: c}
+	for limit <= 0 || len(page.pending) < limit {
+		if !src.next() {
+			return page, "", nil
+		}
+		e := src.current
+		// Entries which share a hash are only found together in an
+		// L-node, so they are visited one after another.
+		if index >= 0 && e.hash == hash {
+			index++
+		} else {
+			hash, index = e.hash, 0
+		}
+		page.pending = append(page.pending, e)
+	}
+	if !src.next() {
+		return page, "", nil
+	}
+	return page, makeToken(hash, index), nil
+}
+
+// IterateFrom returns an Iter over the next limit entries of the HashMap
+// after the position token, and the Token to pass to the following call. The
+// first call takes the empty Token, and the empty Token is returned once
+// there are no more entries. If limit is not positive all remaining entries
+// are returned.
+//
+// Called repeatedly on the same read-only snapshot, IterateFrom visits every
+// entry exactly once, so pages which must not miss or repeat entries should
+// be taken from one. On a HashMap that is being written to, each call sees a
+// fresh image, and entries written between calls may or may not be visited.
+// Entries present throughout are visited once, except for keys whose hashes
+// collide in full: a Token holds their index in a list which writes to any
+// of them reorder, so they may be missed or repeated.
+func (m *HashMap[K, V]) IterateFrom(token Token, limit int) (*Iter[K, V], Token, error) {
+	page, next, err := iterateFrom(m.ct.Snapshot(ReadOnly), token, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	return &Iter[K, V]{cur: page}, next, nil
+}
@@ -0,0 +1,396 @@
+package sled
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+	"sync"
+)
+
+// A trie file lays out the keys and values of a sled as a hash trie with the
+// branching of a C-node, to be searched in place once mapped into memory:
+//
+//	file   = header record* footer
+//	header = magic version:uint16 codeclen:byte codec k0:uint64 k1:uint64
+//	record = node | leaf
+//	node   = kindNode:byte bitmap:uint64 child:uint64*popcount(bitmap)
+//	leaf   = kindLeaf:byte length:uint32 crc:uint32 payload[length]
+//	payload = count:uvarint entry*count
+//	entry  = keylen:uvarint key deadline:varint valuelen:uvarint value
+//	footer = root:uint64 count:uint64 bytes:int64 crc:uint32 magic
+//
+// The magic is "SLEDTRIE" and integers are little endian. Keys are hashed
+// with SipHash-2-4 under the key k0, k1. A node branches on 6 bits of the
+// hash at a time, lowest first: bit i of its bitmap is set if it has a child
+// for those bits being i, and its children are the offsets of their records
+// in the order of the bits. A child holding a single key, or keys whose
+// hashes are all equal, is a leaf. The root is a node. Records are written
+// children first, so the root is the last one.
+//
+// Values are encoded by EncodeValue with the codec the header names, and a
+// deadline is as in a snapshot file. count is the number of entries and bytes
+// their estimated memory, as reported by Len and Bytes. The crc of a leaf is
+// the CRC-32C of its payload, and that of the footer covers the header and
+// the rest of the footer.
+const (
+	trieMagic   = "SLEDTRIE"
+	trieVersion = 1
+	trieFooter  = 8 + 8 + 8 + 4 + len(trieMagic)
+
+	kindNode byte = 1
+	kindLeaf byte = 2
+)
+
+// ErrCorruptTrie is returned when a trie file is malformed.
+var ErrCorruptTrie = errors.New("corrupt trie file")
+
+// trieEntry is an entry being written to a trie file.
+type trieEntry struct {
+	hash uint64
+	key  string
+	at   int64
+	v    interface{}
+}
+
+// trieWriter writes the records of a trie file, tracking their offsets.
+type trieWriter struct {
+	cw    *countWriter
+	bw    *bufio.Writer
+	codec Codec
+	k0    uint64
+	k1    uint64
+	buf   []byte
+	value []byte
+}
+
+// WriteTrie writes the keys and values of a point in time image of s to w,
+// as a trie file which OpenTrie maps into memory. Values are encoded by the
+// Codec set by WithCodec, by default GobCodec, so their types must be
+// registered with RegisterType. Keys which have expired are left out, and
+// those with a time to live keep it, unless s is a Sled implemented outside
+// this package, whose deadlines cannot be read.
+//
+// The file is laid out by the hashes of the keys, so every key of the image
+// is held in memory with its hash and value while they are sorted by hash
+// and written. The values of a sled are shared with it, but those of a trie
+// file or of a Sled implemented outside this package are decoded, so
+// WriteTrie may need as much memory as the whole image.
+func WriteTrie(w io.Writer, s Sled, opts ...Option) (int64, error) {
+	cfg := newConfig(opts)
+	tw := &trieWriter{cw: &countWriter{w: w}, codec: cfg.codec}
+	if tw.codec == nil {
+		tw.codec = GobCodec{}
+	}
+	tw.bw = bufio.NewWriter(tw.cw)
+	var key [16]byte
+	rand.Read(key[:])
+	tw.k0, tw.k1 = binary.LittleEndian.Uint64(key[:]), binary.LittleEndian.Uint64(key[8:])
+
+	var (
+		entries []trieEntry
+		size    int64
+	)
+	snap := s.Snapshot(ReadOnly)
+	defer snap.Close()
+	add := func(key string, at int64, v interface{}) {
+		e := trieEntry{sipHashString(tw.k0, tw.k1, key), key, at, v}
+		entries = append(entries, e)
+		if at != 0 {
+			size += entrySize(key, &wrapped{value: v, deadline: at})
+		} else {
+			size += entrySize(key, v)
+		}
+	}
+	switch sl := snap.(type) {
+	case *sled:
+		for k, v := range sl.m.All() {
+			var at int64
+			if e, ok := v.(*wrapped); ok {
+				if e.deadline != 0 && e.deadline <= sl.frozen {
+					continue
+				}
+				v, at = e.value, e.deadline
+			}
+			add(k, at, v)
+		}
+	case *mappedSled:
+		c := sl.cursor(sl.t.root)
+		for c.next() {
+			if c.live {
+				add(c.key, c.at, c.value)
+			}
+		}
+		if c.err != nil {
+			return tw.cw.n, c.err
+		}
+	default:
+		for k, v := range snap.All() {
+			add(k, 0, v)
+		}
+	}
+
+	header := append([]byte(trieMagic), 0, 0, byte(len(tw.codec.Name())))
+	binary.LittleEndian.PutUint16(header[len(trieMagic):], trieVersion)
+	header = append(header, tw.codec.Name()...)
+	header = binary.LittleEndian.AppendUint64(header, tw.k0)
+	header = binary.LittleEndian.AppendUint64(header, tw.k1)
+	tw.bw.Write(header)
+
+	root, err := tw.node(entries, 0)
+	if err != nil {
+		return tw.cw.n, err
+	}
+	footer := binary.LittleEndian.AppendUint64(nil, root)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(entries)))
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(size))
+	crc := crc32.Update(crc32.Checksum(header, crcTable), crcTable, footer)
+	footer = binary.LittleEndian.AppendUint32(footer, crc)
+	footer = append(footer, trieMagic...)
+	if _, err := tw.bw.Write(footer); err != nil {
+		return tw.cw.n, err
+	}
+	err = tw.bw.Flush()
+	return tw.cw.n, err
+}
+
+// offset returns the offset of the next record.
+func (tw *trieWriter) offset() uint64 {
+	return uint64(tw.cw.n + int64(tw.bw.Buffered()))
+}
+
+// node writes the subtrie of entries which share the lowest lev bits of
+// their hashes, and returns the offset of its root node.
+func (tw *trieWriter) node(entries []trieEntry, lev uint) (uint64, error) {
+	var buckets [1 << w][]trieEntry
+	for _, e := range entries {
+		i := (e.hash >> lev) & 0x3f
+		buckets[i] = append(buckets[i], e)
+	}
+	var (
+		bmp      uint64
+		children []uint64
+	)
+	for i, b := range buckets {
+		if len(b) == 0 {
+			continue
+		}
+		var (
+			off uint64
+			err error
+		)
+		if sameHash(b) {
+			off, err = tw.leaf(b)
+		} else {
+			off, err = tw.node(b, lev+w)
+		}
+		if err != nil {
+			return 0, err
+		}
+		bmp |= 1 << uint(i)
+		children = append(children, off)
+	}
+	off := tw.offset()
+	tw.buf = append(tw.buf[:0], kindNode)
+	tw.buf = binary.LittleEndian.AppendUint64(tw.buf, bmp)
+	for _, c := range children {
+		tw.buf = binary.LittleEndian.AppendUint64(tw.buf, c)
+	}
+	_, err := tw.bw.Write(tw.buf)
+	return off, err
+}
+
+func sameHash(entries []trieEntry) bool {
+	for _, e := range entries[1:] {
+		if e.hash != entries[0].hash {
+			return false
+		}
+	}
+	return true
+}
+
+// leaf writes entries which share a hash, in key order, and returns the
+// offset of the leaf.
+func (tw *trieWriter) leaf(entries []trieEntry) (uint64, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	off := tw.offset()
+	tw.buf = append(tw.buf[:0], kindLeaf, 0, 0, 0, 0, 0, 0, 0, 0)
+	tw.buf = binary.AppendUvarint(tw.buf, uint64(len(entries)))
+	for _, e := range entries {
+		var err error
+		if tw.value, err = EncodeValue(tw.value[:0], tw.codec, e.v); err != nil {
+			return 0, fmt.Errorf("key %q: %w", e.key, err)
+		}
+		tw.buf = binary.AppendUvarint(tw.buf, uint64(len(e.key)))
+		tw.buf = append(tw.buf, e.key...)
+		tw.buf = binary.AppendVarint(tw.buf, e.at)
+		tw.buf = binary.AppendUvarint(tw.buf, uint64(len(tw.value)))
+		tw.buf = append(tw.buf, tw.value...)
+	}
+	payload := tw.buf[9:]
+	binary.LittleEndian.PutUint32(tw.buf[1:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(tw.buf[5:], crc32.Checksum(payload, crcTable))
+	_, err := tw.bw.Write(tw.buf)
+	return off, err
+}
+
+// trieFile is a trie file mapped into memory, shared by the sleds opened on
+// it. It is unmapped when the last of them is closed; its lock keeps it
+// mapped while it is read.
+type trieFile struct {
+	mu    sync.RWMutex
+	data  []byte
+	unmap func() error
+	refs  int
+
+	codec  Codec
+	k0, k1 uint64
+	root   uint64
+	count  uint64
+	bytes  int64
+
+	// sorted caches the keys in order for ordered scans.
+	sorted sortedKeys
+}
+
+// openTrieFile maps the trie file at path into memory, and checks its header
+// and footer. c decodes the values if it has the name of their codec.
+func openTrieFile(path string, c Codec) (*trieFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, unmap, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+	t := &trieFile{data: data, unmap: unmap, refs: 1}
+	if err := t.parse(c); err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *trieFile) parse(c Codec) error {
+	data := t.data
+	n := len(trieMagic) + 3
+	if len(data) < n+trieFooter || string(data[:len(trieMagic)]) != trieMagic {
+		return ErrCorruptTrie
+	}
+	if v := binary.LittleEndian.Uint16(data[len(trieMagic):]); v != trieVersion {
+		return fmt.Errorf("unsupported trie file version %d", v)
+	}
+	n += int(data[n-1])
+	if len(data) < n+16+trieFooter {
+		return ErrCorruptTrie
+	}
+	name := string(data[len(trieMagic)+3 : n])
+	codec, ok := codecNamed(name, c)
+	if !ok {
+		return fmt.Errorf("unknown codec %q", name)
+	}
+	header := data[:n+16]
+	footer := data[len(data)-trieFooter:]
+	crc := crc32.Update(crc32.Checksum(header, crcTable), crcTable, footer[:24])
+	if string(footer[28:]) != trieMagic || binary.LittleEndian.Uint32(footer[24:]) != crc {
+		return ErrCorruptTrie
+	}
+	t.codec = codec
+	t.k0 = binary.LittleEndian.Uint64(header[n:])
+	t.k1 = binary.LittleEndian.Uint64(header[n+8:])
+	t.root = binary.LittleEndian.Uint64(footer)
+	t.count = binary.LittleEndian.Uint64(footer[8:])
+	t.bytes = int64(binary.LittleEndian.Uint64(footer[16:]))
+	return nil
+}
+
+// node returns the bitmap and the children of the node at off. The caller
+// holds t.mu.
+func (t *trieFile) node(off uint64) (bmp uint64, children []byte, err error) {
+	if off > uint64(len(t.data)-9) || t.data[off] != kindNode {
+		return 0, nil, ErrCorruptTrie
+	}
+	bmp = binary.LittleEndian.Uint64(t.data[off+1:])
+	start := off + 9
+	end := start + 8*uint64(bits.OnesCount64(bmp))
+	if end > uint64(len(t.data)) {
+		return 0, nil, ErrCorruptTrie
+	}
+	return bmp, t.data[start:end], nil
+}
+
+// leaf returns the entries of the leaf at off, after their count. The caller
+// holds t.mu.
+func (t *trieFile) leaf(off uint64) (entries []byte, count uint64, err error) {
+	if off > uint64(len(t.data)-9) || t.data[off] != kindLeaf {
+		return nil, 0, ErrCorruptTrie
+	}
+	length := uint64(binary.LittleEndian.Uint32(t.data[off+1:]))
+	if off+9+length > uint64(len(t.data)) {
+		return nil, 0, ErrCorruptTrie
+	}
+	payload := t.data[off+9 : off+9+length]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(t.data[off+5:]) {
+		return nil, 0, ErrCorruptTrie
+	}
+	count, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return nil, 0, ErrCorruptTrie
+	}
+	return payload[n:], count, nil
+}
+
+// isLeaf reports whether the record at off is a leaf. The caller holds
+// t.mu.
+func (t *trieFile) isLeaf(off uint64) bool {
+	return off < uint64(len(t.data)) && t.data[off] == kindLeaf
+}
+
+// find returns the deadline and the decoded value of key. The caller holds
+// t.mu.
+func (t *trieFile) find(key string) (at int64, v interface{}, ok bool, err error) {
+	hash := sipHashString(t.k0, t.k1, key)
+	off := t.root
+	for lev := uint(0); ; lev += w {
+		if t.isLeaf(off) {
+			entries, _, err := t.leaf(off)
+			if err != nil {
+				return 0, nil, false, err
+			}
+			for len(entries) > 0 {
+				k, at, value, n := readEntry(entries)
+				if n <= 0 {
+					return 0, nil, false, ErrCorruptTrie
+				}
+				if k == key {
+					v, err := DecodeValue(t.codec, value)
+					return at, v, err == nil, err
+				}
+				entries = entries[n:]
+			}
+			return 0, nil, false, nil
+		}
+		if lev >= exp2 {
+			return 0, nil, false, ErrCorruptTrie
+		}
+		bmp, children, err := t.node(off)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		flag, pos := flagPos(hash, lev, bmp)
+		if bmp&flag == 0 {
+			return 0, nil, false, nil
+		}
+		off = binary.LittleEndian.Uint64(children[8*pos:])
+	}
+}
@@ -0,0 +1,400 @@
+package sled
+
+import (
+	"container/heap"
+	"iter"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wrapped is stored in the trie in place of the value of a key which has a
+// time to live, or of every key of a sled which accounts for the memory it
+// holds. deadline is 0 for a key which does not expire, and size is the
+// size of the entry measured when it was written. A wrapped value is never
+// modified once stored.
+type wrapped struct {
+	value    interface{}
+	deadline int64
+	size     int64
+}
+
+// wrap returns what is stored in the trie for the value v of key, which
+// expires at deadline unless it is 0.
+func (s *sled) wrap(key string, v interface{}, deadline int64) interface{} {
+	if !s.sized && deadline == 0 {
+		return v
+	}
+	w := &wrapped{value: v, deadline: deadline}
+	if s.sized {
+		// The entry is measured as if it were stored by a sled which
+		// does not account, so Bytes agrees for either.
+		if deadline != 0 {
+			w.size = entrySize(key, w)
+		} else {
+			w.size = entrySize(key, v)
+		}
+	}
+	return w
+}
+
+// clock returns the time against which deadlines are checked, in Unix
+// nanoseconds. A read-only snapshot keeps the time it was taken, so keys do
+// not expire from it.
+func (s *sled) clock() int64 {
+	if s.frozen != 0 {
+		return s.frozen
+	}
+	return time.Now().UnixNano()
+}
+
+// live unwraps a stored value, reporting false if it has expired.
+func (s *sled) live(v interface{}) (interface{}, bool) {
+	return liveAt(v, s.clock())
+}
+
+// liveAt is live for a time read once by the caller.
+func liveAt(v interface{}, now int64) (interface{}, bool) {
+	if e, ok := v.(*wrapped); ok {
+		return e.value, e.deadline == 0 || e.deadline > now
+	}
+	return v, true
+}
+
+// load returns the value stored for key, if it has not expired.
+func (s *sled) load(key string) (interface{}, bool) {
+	v, ok := s.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return s.live(v)
+}
+
+// SetWithTTL assigns value to key, replacing any previous value, and expires
+// the key once ttl has passed. An expired key is immediately invisible to
+// reads, and is removed in the background soon after. Until then it is still
+// counted by Size and Len. A ttl which is not positive deletes the key.
+// SetWithTTL returns ErrReadOnly on a read-only snapshot.
+func (s *sled) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := s.m.ct.writable(); err != nil {
+		return err
+	}
+	defer s.lock()()
+	if ttl <= 0 {
+		s.delete(key)
+		return s.logErr()
+	}
+	data, err := s.encode(value)
+	if err != nil {
+		return err
+	}
+	deadline := s.clock() + int64(ttl)
+	e := s.wrap(key, value, deadline)
+	// The deadline is scheduled before the key is stored, so a read-write
+	// snapshot which holds the key inherits it.
+	s.expireAt(key, deadline)
+	old, existed := s.m.Swap(key, e)
+	s.wrote(key, old, existed, e, true, data)
+	return s.logErr()
+}
+
+// Expire sets key to expire once ttl has passed, replacing any previous time
+// to live. It returns false if the key is not set, or the sled is a read-only
+// snapshot. A ttl which is not positive deletes the key.
+func (s *sled) Expire(key string, ttl time.Duration) bool {
+	if s.m.ct.readOnly {
+		return false
+	}
+	defer s.lock()()
+	if ttl <= 0 {
+		_, existed := s.delete(key)
+		return existed
+	}
+	now := s.clock()
+	deadline := now + int64(ttl)
+	var found bool
+	err := s.update(key, func(old interface{}, exists bool) (interface{}, bool) {
+		if exists {
+			old, exists = liveAt(old, now)
+		}
+		found = exists
+		if !exists {
+			return nil, false
+		}
+		return s.wrap(key, old, deadline), true
+	})
+	if !found || err != nil {
+		return false
+	}
+	// The deadline is only scheduled once the key is known to be set, as
+	// the update may be attempted more than once. A read-write snapshot
+	// taken in between does not inherit it: the key expires from it, but
+	// is not swept.
+	s.expireAt(key, deadline)
+	return true
+}
+
+// expireAt schedules the removal of key at deadline, starting the sweeper on
+// first use.
+func (s *sled) expireAt(key string, deadline int64) {
+	s.sweepOnce.Do(s.startSweep)
+	if s.sweep != nil {
+		// The sled is not closed.
+		s.sweep.schedule(key, deadline)
+	}
+}
+
+// startSweep starts the sweeper, and schedules the deadlines a read-write
+// snapshot inherited from its source.
+func (s *sled) startSweep() {
+	s.sweep = newSweeper(s.removeExpired)
+	if s.inherited != nil {
+		for _, d := range s.inherited() {
+			s.sweep.schedule(d.key, d.at)
+		}
+	}
+	atomic.StoreInt32(&s.expires, 1)
+}
+
+// inherit returns a read-write snapshot of the sled, and a function which
+// lists the deadlines of its keys. A sled whose sweeper is running copies
+// the deadlines pending when the snapshot is taken; the keys of a read-only
+// snapshot are read for theirs when they are listed.
+func (s *sled) inherit() (*Map[interface{}], func() []deadline) {
+	m := s.m.Snapshot(ReadWrite)
+	switch {
+	case atomic.LoadInt32(&s.expires) == 1:
+		// The snapshot is taken again, as the sweeper may have started
+		// after the first one.
+		m, pending := s.sweep.pending(func() *Map[interface{}] {
+			return s.m.Snapshot(ReadWrite)
+		})
+		return m, func() []deadline { return pending }
+	case s.inherited != nil:
+		return m, s.inherited
+	case s.frozen != 0:
+		return m, func() []deadline {
+			var pending []deadline
+			for k, v := range s.m.All() {
+				if e, ok := v.(*wrapped); ok && e.deadline != 0 {
+					pending = append(pending, deadline{k, e.deadline})
+				}
+			}
+			return pending
+		}
+	}
+	return m, nil
+}
+
+// removeExpired removes key through the trie's conditional remove, if it
+// still holds a value which has expired by now. A key which was written
+// again since it was scheduled is left alone, and scheduled again if it was
+// given a later deadline.
+func (s *sled) removeExpired(key string, now int64) {
+	defer s.lock()()
+	var (
+		later   int64
+		removed bool
+	)
+	expired := condition[interface{}](func(v interface{}, exists bool) bool {
+		later = 0
+		if e, ok := v.(*wrapped); ok && exists && e.deadline > now {
+			later = e.deadline
+		}
+		_, ok := liveAt(v, now)
+		return exists && !ok
+	})
+	if prev, _ := s.m.ct.RemoveIf(key, expired.recorded(&removed)); removed {
+		s.wrote(key, prev, true, nil, false, nil)
+	} else if later != 0 {
+		s.expireAt(key, later)
+	}
+}
+
+// sweeper removes keys when their deadlines pass. Deadlines are kept in a
+// min-heap, one for each key, served by a goroutine which sleeps until the
+// earliest one. The goroutine exits once no deadline is left, and is started
+// again by the next one scheduled.
+type sweeper struct {
+	mu        sync.Mutex
+	deadlines deadlineHeap
+	due       []deadline
+	running   bool
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	remove    func(key string, now int64)
+}
+
+type deadline struct {
+	key string
+	at  int64
+}
+
+// deadlineHeap implements heap.Interface, earliest deadline first. index
+// holds the position of the deadline of each key.
+type deadlineHeap struct {
+	items []deadline
+	index map[string]int
+}
+
+func (h *deadlineHeap) Len() int           { return len(h.items) }
+func (h *deadlineHeap) Less(i, j int) bool { return h.items[i].at < h.items[j].at }
+func (h *deadlineHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].key] = i
+	h.index[h.items[j].key] = j
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	d := x.(deadline)
+	h.index[d.key] = len(h.items)
+	h.items = append(h.items, d)
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	d := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, d.key)
+	return d
+}
+
+func newSweeper(remove func(key string, now int64)) *sweeper {
+	return &sweeper{
+		deadlines: deadlineHeap{index: make(map[string]int)},
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		remove:    remove,
+	}
+}
+
+// schedule adds a deadline for key, or moves its deadline earlier. A later
+// deadline is left to removeExpired to schedule once the earlier one has
+// passed. The goroutine is started, or woken if the deadline is the
+// earliest.
+func (sw *sweeper) schedule(key string, at int64) {
+	sw.mu.Lock()
+	if i, ok := sw.deadlines.index[key]; !ok {
+		heap.Push(&sw.deadlines, deadline{key, at})
+	} else if at < sw.deadlines.items[i].at {
+		sw.deadlines.items[i].at = at
+		heap.Fix(&sw.deadlines, i)
+	}
+	first := sw.deadlines.items[0].key == key
+	start := !sw.running
+	if start {
+		select {
+		case <-sw.done:
+			start = false
+		default:
+			sw.running = true
+		}
+	}
+	sw.mu.Unlock()
+	if start {
+		go sw.run()
+	} else if first {
+		select {
+		case sw.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pending calls take while no deadline is scheduled or removed, and returns
+// its result with a copy of the deadlines not yet served, including those
+// being served.
+func (sw *sweeper) pending(take func() *Map[interface{}]) (*Map[interface{}], []deadline) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	m := take()
+	return m, append(slices.Clone(sw.deadlines.items), sw.due...)
+}
+
+func (sw *sweeper) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		var due []deadline
+		sw.mu.Lock()
+		now := time.Now().UnixNano()
+		for sw.deadlines.Len() > 0 && sw.deadlines.items[0].at <= now {
+			due = append(due, heap.Pop(&sw.deadlines).(deadline))
+		}
+		if due == nil && sw.deadlines.Len() == 0 {
+			sw.running = false
+			sw.mu.Unlock()
+			return
+		}
+		var wait time.Duration
+		if due == nil {
+			wait = time.Duration(sw.deadlines.items[0].at - now)
+		}
+		sw.due = due
+		sw.mu.Unlock()
+
+		if due != nil {
+			for _, d := range due {
+				sw.remove(d.key, now)
+			}
+			sw.mu.Lock()
+			sw.due = nil
+			sw.mu.Unlock()
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-sw.wake:
+		case <-sw.done:
+			return
+		}
+	}
+}
+
+// close stops the goroutine, and keeps it from being started again. Keys
+// which expire later are no longer removed, but remain invisible.
+func (sw *sweeper) close() {
+	sw.closeOnce.Do(func() {
+		close(sw.done)
+	})
+}
+
+// liveIter skips the keys of an Iterator which expired before now, and
+// unwraps the values of those which have a time to live.
+type liveIter struct {
+	Iterator
+	now   int64
+	value interface{}
+}
+
+func (it *liveIter) Next() bool {
+	for it.Iterator.Next() {
+		if v, ok := liveAt(it.Iterator.Value(), it.now); ok {
+			it.value = v
+			return true
+		}
+	}
+	it.value = nil
+	return false
+}
+
+func (it *liveIter) Value() interface{} {
+	return it.value
+}
+
+// liveFunc wraps fn so it is only called for the keys which had not expired
+// by now, with their values unwrapped.
+func liveFunc(now int64, fn func(key string, value interface{}) bool) func(string, interface{}) bool {
+	return func(key string, value interface{}) bool {
+		v, ok := liveAt(value, now)
+		return !ok || fn(key, v)
+	}
+}
+
+// liveSeq filters a sequence as liveFunc does.
+func liveSeq(now int64, seq iter.Seq2[string, interface{}]) iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		seq(liveFunc(now, yield))
+	}
+}
@@ -0,0 +1,75 @@
+package sled
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweeper(t *testing.T) {
+	assert := assert.New(t)
+	var (
+		mu      sync.Mutex
+		removed []string
+	)
+	sw := newSweeper(func(key string, now int64) {
+		mu.Lock()
+		removed = append(removed, key)
+		mu.Unlock()
+	})
+	defer sw.close()
+	idle := func() bool {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		return !sw.running
+	}
+	assert.True(idle())
+
+	// A key has one deadline, the earliest scheduled.
+	now := time.Now().UnixNano()
+	sw.schedule("a", now+int64(time.Hour))
+	sw.schedule("a", now+int64(2*time.Hour))
+	sw.schedule("b", now+int64(time.Hour))
+	sw.schedule("a", now+int64(time.Minute))
+	sw.mu.Lock()
+	assert.Equal(2, sw.deadlines.Len())
+	assert.Equal(deadline{"a", now + int64(time.Minute)}, sw.deadlines.items[0])
+	for i, d := range sw.deadlines.items {
+		assert.Equal(i, sw.deadlines.index[d.key])
+	}
+	sw.mu.Unlock()
+
+	// The goroutine exits once every deadline has been served.
+	sw2 := newSweeper(sw.remove)
+	defer sw2.close()
+	sw2.schedule("c", time.Now().Add(time.Millisecond).UnixNano())
+	assert.Eventually(func() bool {
+		sw2.mu.Lock()
+		defer sw2.mu.Unlock()
+		return !sw2.running
+	}, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal([]string{"c"}, removed)
+	mu.Unlock()
+}
+
+func TestExpireLater(t *testing.T) {
+	assert := assert.New(t)
+	s := New().(*sled)
+	defer s.Close()
+	assert.NoError(s.SetWithTTL("k", 1, 20*time.Millisecond))
+	assert.NoError(s.SetWithTTL("k", 2, time.Hour))
+
+	// The earlier deadline passes, and the key is scheduled again for its
+	// own.
+	assert.Eventually(func() bool {
+		s.sweep.mu.Lock()
+		defer s.sweep.mu.Unlock()
+		return s.sweep.deadlines.Len() == 1 && s.sweep.deadlines.items[0].at > time.Now().Add(time.Minute).UnixNano()
+	}, 5*time.Second, time.Millisecond)
+	var v int
+	assert.NoError(s.Get("k", &v))
+	assert.Equal(2, v)
+}
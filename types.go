@@ -9,6 +9,35 @@ type Element interface {
 	Close()
 }
 
+// Iterator is a cursor over the keys and values of a point in time image of
+// a sled. It needs no goroutine and no closing, so it may be abandoned before
+// reaching the end.
+type Iterator interface {
+	// Next advances to the next key and value, returning false when there
+	// are no more.
+	Next() bool
+
+	// Key and Value return the current key and value. Until Next first
+	// returns true they return "" and nil.
+	Key() string
+	Value() interface{}
+
+	// Err returns the reason the iteration stopped early, or nil if it
+	// ran to completion.
+	Err() error
+}
+
+// UpdateFunc computes the new value for a key from its current value. exists
+// is false if the key is not set. Returning keep == false deletes the key, or
+// leaves it unset.
+type UpdateFunc func(old interface{}, exists bool) (value interface{}, keep bool)
+
+// Equaler can be implemented by values to control how CompareAndSwap and
+// CompareAndDelete compare them with the stored value.
+type Equaler interface {
+	Equal(v interface{}) bool
+}
+
 // IoMode represents the mode of a sled as either ReadWrite, or ReadOnly.
 type IoMode uint
 
@@ -1,7 +1,6 @@
 package sled
 
 import (
-	"bytes"
 	"sync/atomic"
 	"unsafe"
 )
@@ -10,51 +9,51 @@ import (
 // with at least one branch. If a given C-Node has only a single S-node below
 // it and is not at the root level, a T-node which wraps the S-node is
 // returned.
-func toContracted(cn *cNode, lev uint) *node {
+func toContracted[K, V any](cn *cNode[K, V], lev uint) *node[K, V] {
 	if lev > 0 && len(cn.array) == 1 {
 		branch := cn.array[0]
 		switch branch.(type) {
-		case *sNode:
-			return entomb(branch.(*sNode))
+		case *sNode[K, V]:
+			return entomb(branch.(*sNode[K, V]))
 		default:
-			return &node{cNode: cn}
+			return &node[K, V]{cNode: cn}
 		}
 	}
-	return &node{cNode: cn}
+	return &node[K, V]{cNode: cn}
 }
 
 // toCompressed compacts the C-node as a performance optimization.
-func toCompressed(cn *cNode, lev uint) *node {
+func toCompressed[K, V any](cn *cNode[K, V], lev uint) *node[K, V] {
 	tmpArray := make([]branch, len(cn.array))
 	for i, sub := range cn.array {
 		switch sub.(type) {
-		case *iNode:
-			inode := sub.(*iNode)
+		case *iNode[K, V]:
+			inode := sub.(*iNode[K, V])
 			mainPtr := (*unsafe.Pointer)(unsafe.Pointer(&inode.main))
-			main := (*node)(atomic.LoadPointer(mainPtr))
+			main := (*node[K, V])(atomic.LoadPointer(mainPtr))
 			tmpArray[i] = resurrect(inode, main)
-		case *sNode:
+		case *sNode[K, V]:
 			tmpArray[i] = sub
 		default:
 			panic("invalid state")
 		}
 	}
 
-	return toContracted(&cNode{bmp: cn.bmp, array: tmpArray}, lev)
+	return toContracted(&cNode[K, V]{bmp: cn.bmp, array: tmpArray}, lev)
 }
 
-func entomb(m *sNode) *node {
-	return &node{tNode: &tNode{m}}
+func entomb[K, V any](m *sNode[K, V]) *node[K, V] {
+	return &node[K, V]{tNode: &tNode[K, V]{m}}
 }
 
-func resurrect(iNode *iNode, main *node) branch {
+func resurrect[K, V any](iNode *iNode[K, V], main *node[K, V]) branch {
 	if main.tNode != nil {
 		return main.tNode.untombed()
 	}
 	return iNode
 }
 
-func clean(i *iNode, lev uint, c *ctrie) bool {
+func clean[K, V any](i *iNode[K, V], lev uint, c *ctrie[K, V]) bool {
 	main := gcasRead(i, c)
 	if main.cNode != nil {
 		return gcas(i, main, toCompressed(main.cNode, lev), c)
@@ -62,18 +61,19 @@ func clean(i *iNode, lev uint, c *ctrie) bool {
 	return true
 }
 
-func cleanReadOnly(tn *tNode, lev uint, p *iNode, c *ctrie, e *entry) (val interface{}, exists bool, ok bool) {
+func cleanReadOnly[K, V any](tn *tNode[K, V], lev uint, p *iNode[K, V], c *ctrie[K, V], e *entry[K, V]) (val V, exists bool, ok bool) {
+	var zero V
 	if !c.readOnly {
-		clean(p, lev-5, c)
-		return nil, false, false
+		clean(p, lev-w, c)
+		return zero, false, false
 	}
-	if tn.hash == e.hash && bytes.Equal(tn.Key, e.Key) {
+	if tn.hash == e.hash && c.keys.equal(tn.Key, e.Key) {
 		return tn.Value, true, true
 	}
-	return nil, false, true
+	return zero, false, true
 }
 
-func cleanParent(p, i *iNode, hc uint64, lev uint, c *ctrie, startGen *generation) {
+func cleanParent[K, V any](p, i *iNode[K, V], hc uint64, lev uint, c *ctrie[K, V], startGen *generation) {
 	n := aln(&i.main)
 	pn := aln(&p.main)
 	// var (
@@ -0,0 +1,610 @@
+package sled
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy chooses when the write-ahead log of a durable sled is flushed
+// to stable storage. Every write reaches the operating system before it
+// returns, so a crash of the process alone loses nothing; the policy bounds
+// what a crash of the machine can lose.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every write, before it returns.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval flushes the log periodically, at the interval set by
+	// WithSyncInterval.
+	SyncInterval
+
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+const (
+	defaultSyncInterval = time.Second
+	defaultSegmentSize  = 64 << 20
+)
+
+// ErrCorruptLog is returned by Open when a record of the write-ahead log
+// fails its checksum anywhere but at the end of the log: a damaged record is
+// taken to be torn by a crash only if no whole record follows it.
+var ErrCorruptLog = errors.New("corrupt log")
+
+// The write-ahead log is a sequence of segment files in one directory, each
+// named for the sequence number of its first record, in hex:
+//
+//	segment = magic version:uint16 codeclen:byte codec record*
+//	record  = length:uint32 crc:uint32 payload[length]
+//	payload = seq:uvarint op:byte keylen:uvarint key [deadline:varint] [value]
+//
+// The magic is "SLEDWLOG", integers are little endian, and crc is the
+// CRC-32C of the payload. Records are numbered from 1. The deadline of
+// opSetTTL is in Unix nanoseconds, and values are encoded by EncodeValue with
+// the codec the segment names.
+const (
+	walMagic   = "SLEDWLOG"
+	walVersion = 1
+)
+
+const (
+	opSet byte = iota + 1
+	opSetTTL
+	opDelete
+)
+
+const walHeader = 8
+
+// minRecord is the length of the shortest record: a header, a one byte
+// sequence number and op, and the length of an empty key.
+const minRecord = walHeader + 3
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn reports a record cut short or failing its checksum, as the last
+// record written before a crash may be. Whether it is the last is up to the
+// caller; see recordAfter.
+var errTorn = errors.New("torn record")
+
+// record is one logged write.
+type record struct {
+	seq      uint64
+	op       byte
+	key      string
+	deadline int64
+	value    interface{}
+	data     []byte // the value already encoded, if not nil
+}
+
+// appendSegmentHeader appends the header of a segment of values encoded by c
+// to buf.
+func appendSegmentHeader(buf []byte, c Codec) []byte {
+	buf = append(buf, walMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, walVersion)
+	buf = append(buf, byte(len(c.Name())))
+	return append(buf, c.Name()...)
+}
+
+// readSegmentHeader returns the codec named by the header at the start of a
+// segment, and the length of the header. c is used if the header names it,
+// and otherwise the built-in codec named.
+func readSegmentHeader(data []byte, c Codec) (Codec, int, error) {
+	n := len(walMagic) + 3
+	if len(data) < n || len(data) < n+int(data[n-1]) {
+		return nil, 0, errTorn
+	}
+	if string(data[:len(walMagic)]) != walMagic || binary.LittleEndian.Uint16(data[len(walMagic):]) != walVersion {
+		return nil, 0, ErrCorruptLog
+	}
+	name := string(data[n : n+int(data[n-1])])
+	c, ok := codecNamed(name, c)
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, n + len(name), nil
+}
+
+// appendRecord appends the encoding of r to buf, with values encoded by c
+// unless r.data holds the encoding.
+func appendRecord(buf []byte, r *record, c Codec) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, walHeader)...)
+	buf = binary.AppendUvarint(buf, r.seq)
+	buf = append(buf, r.op)
+	buf = binary.AppendUvarint(buf, uint64(len(r.key)))
+	buf = append(buf, r.key...)
+	if r.op == opSetTTL {
+		buf = binary.AppendVarint(buf, r.deadline)
+	}
+	if r.op != opDelete && r.data != nil {
+		buf = append(buf, r.data...)
+	} else if r.op != opDelete {
+		var err error
+		if buf, err = EncodeValue(buf, c, r.value); err != nil {
+			return buf[:start], err
+		}
+	}
+	payload := buf[start+walHeader:]
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.Checksum(payload, crcTable))
+	return buf, nil
+}
+
+// readRecord decodes the record at the start of data, and returns its length.
+// The value is left encoded, in value, for DecodeValue.
+func readRecord(data []byte) (r record, value []byte, n int, err error) {
+	if len(data) < walHeader {
+		return r, nil, 0, errTorn
+	}
+	length := binary.LittleEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-walHeader) {
+		return r, nil, 0, errTorn
+	}
+	payload := data[walHeader : walHeader+int(length)]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(data[4:]) {
+		return r, nil, 0, errTorn
+	}
+	p := payload
+	var k uint64
+	var m int
+	if r.seq, m = binary.Uvarint(p); m <= 0 || len(p) < m+1 {
+		return r, nil, 0, ErrCorruptLog
+	}
+	r.op, p = p[m], p[m+1:]
+	if k, m = binary.Uvarint(p); m <= 0 || uint64(len(p)-m) < k {
+		return r, nil, 0, ErrCorruptLog
+	}
+	r.key, p = string(p[m:m+int(k)]), p[m+int(k):]
+	switch r.op {
+	case opSetTTL:
+		if r.deadline, m = binary.Varint(p); m <= 0 {
+			return r, nil, 0, ErrCorruptLog
+		}
+		p = p[m:]
+	case opSet, opDelete:
+	default:
+		return r, nil, 0, ErrCorruptLog
+	}
+	return r, p, walHeader + int(length), nil
+}
+
+// wal appends the writes of a durable sled to its log. Its mutex serializes
+// the writes to the sled, so they are logged in the order they took effect.
+type wal struct {
+	mu          sync.Mutex
+	dir         string
+	f           *os.File
+	size        int64
+	start       uint64 // the first sequence number of f
+	seq         uint64
+	codec       Codec
+	policy      SyncPolicy
+	segmentSize int64
+	dirty       bool
+	buf         []byte
+	done        chan struct{}
+
+	// value is the buffer encode encodes values in.
+	value []byte
+
+	// evicted holds the keys evicted by the write in progress, for the
+	// OnEvict function to be called with once the sled is unlocked.
+	evicted []eviction
+
+	// err is the first error met writing the log. Once it is set, no more
+	// writes are logged.
+	err error
+
+	checkpoint checkpointer
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%016x.wal", seq)
+}
+
+// segmentStart returns the sequence number a segment is named for.
+func segmentStart(path string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".wal"), 16, 64)
+}
+
+// segments returns the paths of the log segments in dir, oldest first.
+func segments(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	sort.Strings(paths)
+	return paths, err
+}
+
+// openWAL replays the records of the log in dir which follow the sequence
+// number after through apply, with the codec of each segment, truncating a
+// torn record at its end. It opens the log for appending values encoded by
+// codec.
+func openWAL(dir string, cfg *config, codec Codec, after uint64, apply func(r record, value []byte, c Codec) error) (*wal, error) {
+	w := &wal{
+		dir:         dir,
+		seq:         after,
+		codec:       codec,
+		policy:      cfg.syncPolicy,
+		segmentSize: cfg.segmentSize,
+	}
+	if w.segmentSize <= 0 {
+		w.segmentSize = defaultSegmentSize
+	}
+	paths, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		last  Codec
+		empty bool // the last segment holds no records
+	)
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c, off, err := readSegmentHeader(data, codec)
+		if err == errTorn && i == len(paths)-1 {
+			// The segment was being created; its records were never
+			// written.
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			paths = paths[:i]
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		last, empty = c, off == len(data)
+		for off < len(data) {
+			r, value, n, err := readRecord(data[off:])
+			if err == errTorn && i == len(paths)-1 && !recordAfter(data[off+1:], w.seq) {
+				if err := os.Truncate(path, int64(off)); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if err == nil && r.seq > after && r.seq != w.seq+1 {
+				// Records are missing.
+				err = ErrCorruptLog
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s at offset %d: %w", path, off, ErrCorruptLog)
+			}
+			off += n
+			if r.seq <= after {
+				// The record is in the checkpoint.
+				continue
+			}
+			if err := apply(r, value, c); err != nil {
+				return nil, fmt.Errorf("%s at offset %d: %w", path, off-n, err)
+			}
+			w.seq = r.seq
+		}
+		w.size = int64(off)
+	}
+	if len(paths) > 0 && empty && last.Name() != codec.Name() {
+		// The segment a new one would replace was left empty by a
+		// rotation, or by a sled closed before it was written.
+		if err := os.Remove(paths[len(paths)-1]); err != nil {
+			return nil, err
+		}
+	}
+	if len(paths) == 0 || last.Name() != codec.Name() {
+		err = w.create()
+	} else {
+		path := paths[len(paths)-1]
+		w.start, err = segmentStart(path)
+		if err == nil {
+			w.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w.policy == SyncInterval {
+		interval := cfg.syncInterval
+		if interval <= 0 {
+			interval = defaultSyncInterval
+		}
+		w.done = make(chan struct{})
+		go w.syncEvery(interval)
+	}
+	return w, nil
+}
+
+// recordAfter reports whether a whole record numbered after seq starts
+// anywhere in data, the rest of a segment after a damaged record. If one
+// does, the damaged record was not the last written, and was not torn by a
+// crash.
+//
+// Records are numbered in order and none is shorter than minRecord, so a
+// record at offset p is numbered at most seq+2+p/minRecord. Offsets whose
+// length, number or op rule them out are passed over without a checksum,
+// which keeps the scan linear in data.
+func recordAfter(data []byte, seq uint64) bool {
+	for p := 0; len(data)-p >= minRecord; p++ {
+		length := binary.LittleEndian.Uint32(data[p:])
+		if length < minRecord-walHeader || uint64(length) > uint64(len(data)-p-walHeader) {
+			continue
+		}
+		payload := data[p+walHeader : p+walHeader+int(length)]
+		n, m := binary.Uvarint(payload)
+		if m <= 0 || m == len(payload) || n <= seq || n-seq > uint64(p/minRecord+2) {
+			continue
+		}
+		if op := payload[m]; op != opSet && op != opSetTTL && op != opDelete {
+			continue
+		}
+		if r, _, _, err := readRecord(data[p:]); err == nil && r.seq > seq {
+			return true
+		}
+	}
+	return false
+}
+
+// create starts a new segment with the next record, and flushes the
+// directory so the segment survives a crash.
+func (w *wal) create() error {
+	f, err := os.OpenFile(filepath.Join(w.dir, segmentName(w.seq+1)), os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	w.f, w.size, w.start = f, 0, w.seq+1
+	header := appendSegmentHeader(nil, w.codec)
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	w.size = int64(len(header))
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return syncDir(w.dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// encode encodes v, the value about to be written, before the write changes
+// the trie. A value which cannot be encoded is refused without changing the
+// sled or stopping the log. The encoding is only valid until the next call.
+// The caller holds w.mu.
+func (w *wal) encode(v interface{}) ([]byte, error) {
+	if e, ok := v.(*wrapped); ok {
+		v = e.value
+	}
+	value, err := EncodeValue(w.value[:0], w.codec, v)
+	w.value = value
+	return value, err
+}
+
+// append logs that key holds v, or was deleted if has is false. data is the
+// encoding of v returned by encode, or nil to encode it here. The caller
+// holds w.mu.
+func (w *wal) append(key string, v interface{}, has bool, data []byte) {
+	if w.err != nil {
+		return
+	}
+	if w.f == nil {
+		w.err = os.ErrClosed
+		return
+	}
+	r := record{seq: w.seq + 1, op: opDelete, key: key}
+	if has {
+		r.op, r.value = opSet, v
+		if e, ok := v.(*wrapped); ok {
+			r.value = e.value
+			if e.deadline != 0 {
+				r.op, r.deadline = opSetTTL, e.deadline
+			}
+		}
+		r.data = data
+	}
+	buf, err := appendRecord(w.buf[:0], &r, w.codec)
+	w.buf = buf
+	if err == nil {
+		_, err = w.f.Write(buf)
+	}
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.seq = r.seq
+	w.size += int64(len(buf))
+	switch {
+	case w.size >= w.segmentSize:
+		err = w.rotate()
+	case w.policy == SyncAlways:
+		err = w.f.Sync()
+	default:
+		w.dirty = true
+	}
+	w.err = err
+}
+
+// rotate flushes and closes the current segment, and starts the next.
+func (w *wal) rotate() error {
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f, w.dirty = nil, false
+	return w.create()
+}
+
+func (w *wal) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.f != nil && w.err == nil {
+				w.err = w.f.Sync()
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// close flushes and closes the log, returning the first error met writing
+// it.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return w.err
+	}
+	if w.done != nil {
+		close(w.done)
+	}
+	err := w.f.Sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+	if w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+// Open returns a durable sled, which appends every write to a write-ahead
+// log in dir before the write returns. The directory is created if needed.
+// The state left in it by a previous process is recovered into the new
+// sled: the last checkpoint is loaded, and the log written since replayed.
+// A record torn by a crash at the end of the log is truncated. Only the sled
+// returned by Open is durable; its snapshots are not.
+//
+// The log records the keys evicted from a sled created WithMaxEntries or
+// WithMaxBytes, so nothing is evicted while it is replayed. The eviction
+// policy learns of the keys as they are replayed; reads are not logged, so
+// their recency is lost.
+//
+// Writes to a durable sled are serialized, while reads are not slowed. A
+// write is visible to readers once it changes the sled, just before it is
+// logged, and returns once it is logged. When the log is flushed to stable
+// storage is set by WithSyncPolicy. Values are encoded in the log by the
+// Codec set by WithCodec, so their types must be registered with
+// RegisterType. A value which cannot be encoded is refused before it is
+// stored: the write returns the error, or reports that it did not take
+// place, and the sled and its log carry on. Once a write fails to reach the
+// log the sled is no longer durable: Set, SetWithTTL, Update and Close return
+// the error.
+func Open(dir string, opts ...Option) (Sled, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cfg := newConfig(opts)
+	s := New(opts...).(*sled)
+	var pending []deadline
+	s.replaying = true
+	after, err := s.readCheckpoint(dir, &pending)
+	var w *wal
+	if err == nil {
+		w, err = openWAL(dir, cfg, s.codec, after, func(r record, value []byte, c Codec) error {
+			return s.replay(r, value, c, &pending)
+		})
+	}
+	s.replaying = false
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	w.checkpoint.seq = after
+	s.wal = w
+	if s.limits != nil && s.over() {
+		// The limits are lower than those the log was written under.
+		unlock := s.lock()
+		s.evict()
+		unlock()
+	}
+	if cfg.checkpointInterval > 0 {
+		w.checkpoint.start(s, cfg.checkpointInterval)
+	}
+	for _, d := range pending {
+		s.expireAt(d.key, d.at)
+	}
+	return s, nil
+}
+
+// replay applies a logged write to the sled, collecting the deadlines of the
+// keys it sets to expire. Keys which have expired since are deleted.
+func (s *sled) replay(r record, value []byte, c Codec, pending *[]deadline) error {
+	if r.op == opSetTTL && r.deadline <= time.Now().UnixNano() {
+		r.op = opDelete
+	}
+	if r.op == opDelete {
+		s.delete(r.key)
+		return nil
+	}
+	v, err := DecodeValue(c, value)
+	if err != nil {
+		return err
+	}
+	var at int64
+	if r.op == opSetTTL {
+		at = r.deadline
+	}
+	s.restore(r.key, v, at, pending)
+	return nil
+}
+
+// lock serializes the writes to a durable sled, and returns the function
+// which unlocks it. Unlocking then calls the OnEvict function for the keys
+// the write evicted, so it may write to the sled itself.
+func (s *sled) lock() func() {
+	if s.wal == nil {
+		return func() {}
+	}
+	s.wal.mu.Lock()
+	return func() {
+		evicted := s.wal.evicted
+		s.wal.evicted = nil
+		s.wal.mu.Unlock()
+		for _, e := range evicted {
+			s.limits.onEvict(e.key, e.value)
+		}
+	}
+}
+
+// encode encodes the value about to be written to a durable sled, before the
+// write changes the trie, returning the error if it cannot be. The encoding
+// is passed on to wrote, which logs it; it is nil if the sled is not
+// durable. The caller holds the lock.
+func (s *sled) encode(value interface{}) ([]byte, error) {
+	if s.wal == nil {
+		return nil, nil
+	}
+	return s.wal.encode(value)
+}
+
+// logErr returns the error which stopped the log of a durable sled.
+func (s *sled) logErr() error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.err
+}
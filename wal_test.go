@@ -0,0 +1,69 @@
+package sled
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	assert := assert.New(t)
+	records := []record{
+		{seq: 1, op: opSet, key: "a", value: "value"},
+		{seq: 2, op: opSetTTL, key: "b", deadline: 1 << 60, value: 42},
+		{seq: 3, op: opDelete, key: "a"},
+		{seq: 4, op: opSet, key: "", value: nil},
+	}
+	var buf []byte
+	for i := range records {
+		var err error
+		buf, err = appendRecord(buf, &records[i], GobCodec{})
+		assert.NoError(err)
+	}
+	for _, want := range records {
+		r, value, n, err := readRecord(buf)
+		assert.NoError(err)
+		assert.Equal(want.seq, r.seq)
+		assert.Equal(want.op, r.op)
+		assert.Equal(want.key, r.key)
+		assert.Equal(want.deadline, r.deadline)
+		if r.op != opDelete {
+			v, err := DecodeValue(GobCodec{}, value)
+			assert.NoError(err)
+			assert.Equal(want.value, v)
+		}
+		buf = buf[n:]
+	}
+	assert.Empty(buf)
+
+	// Every prefix of a record, and any flipped bit, is torn.
+	buf, _ = appendRecord(nil, &records[0], GobCodec{})
+	for i := 0; i < len(buf); i++ {
+		_, _, _, err := readRecord(buf[:i])
+		assert.Equal(errTorn, err)
+	}
+	buf[len(buf)-1] ^= 1
+	_, _, _, err := readRecord(buf)
+	assert.Equal(errTorn, err)
+
+	_, err = appendRecord(nil, &record{seq: 1, op: opSet, value: func() {}}, GobCodec{})
+	assert.Error(err)
+}
+
+func TestRecordAfter(t *testing.T) {
+	assert := assert.New(t)
+	var buf []byte
+	for seq := uint64(1); seq <= 3; seq++ {
+		buf, _ = appendRecord(buf, &record{seq: seq, op: opSet, key: "a", value: "value"}, GobCodec{})
+	}
+	// The rest of the segment after a damaged first record.
+	assert.True(recordAfter(buf[1:], 0))
+	assert.False(recordAfter(buf[1:], 3))
+	assert.False(recordAfter(make([]byte, 1<<20), 0))
+
+	// A record numbered further on than the records before it allow is
+	// passed over.
+	far, _ := appendRecord(nil, &record{seq: 100, op: opDelete, key: "a"}, GobCodec{})
+	assert.False(recordAfter(far, 0))
+	assert.True(recordAfter(far, 98))
+}